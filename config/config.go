@@ -0,0 +1,434 @@
+// Package config defines the typed configuration of the uberclick server.
+// Values are resolved, in increasing order of precedence, from the defaults,
+// a JSON configuration file, UBERCLICK_* environment variables and
+// command line flags.
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/odeke-em/uberclick"
+	"github.com/odeke-em/uberclick/logging"
+)
+
+type Config struct {
+	// HTTP1 if set serves plain HTTP on HTTPAddr instead of
+	// serving TLS via autocert for Domains.
+	HTTP1 bool `json:"http1"`
+
+	// HTTPAddr is the address served in HTTP1 mode.
+	HTTPAddr string `json:"http_addr"`
+
+	// RedirectAddr is the address on which non-HTTPS
+	// traffic is redirected to RedirectURL.
+	RedirectAddr string `json:"redirect_addr"`
+	RedirectURL  string `json:"redirect_url"`
+
+	// Domains are the hosts that autocert provisions certificates for.
+	Domains []string `json:"domains"`
+
+	// MetricsAddr if set is the address on which Prometheus
+	// metrics are served, apart from the public routes.
+	MetricsAddr string `json:"metrics_addr"`
+
+	// StaticDir if set is the directory of static files served
+	// at the root path. It is only looked up when serving, so
+	// that it can be mounted after the server has started.
+	StaticDir string `json:"static_dir"`
+
+	// Store selects the storage backend, either StoreRedis
+	// or StoreFile which keeps everything in StorePath.
+	Store          string `json:"store"`
+	StorePath      string `json:"store_path"`
+	RedisServerURL string `json:"redis_server_url"`
+
+	OAuth2ClientID     string `json:"oauth2_client_id"`
+	OAuth2ClientSecret string `json:"oauth2_client_secret"`
+
+	// DrainDelay is how long readiness checks fail on shutdown
+	// before new connections are refused, giving load balancers
+	// time to stop routing here. ShutdownTimeout is how long
+	// in-flight requests are then given before being cancelled.
+	DrainDelay      Duration `json:"drain_delay"`
+	ShutdownTimeout Duration `json:"shutdown_timeout"`
+
+	// StoreTimeout and UpstreamTimeout bound each store
+	// operation and each call to the Uber API respectively.
+	StoreTimeout    Duration `json:"store_timeout"`
+	UpstreamTimeout Duration `json:"upstream_timeout"`
+
+	// EstimateCacheTTL is how long the estimates of a trip are
+	// reused for the same user. Negative durations disable it.
+	EstimateCacheTTL Duration `json:"estimate_cache_ttl"`
+
+	// ProductsCacheTTL is how long the products offered at a
+	// location are reused. Negative durations disable it.
+	ProductsCacheTTL Duration `json:"products_cache_ttl"`
+
+	// GeocoderPlaces if set is the path of a JSON array of
+	// places that addresses are resolved against.
+	GeocoderPlaces string `json:"geocoder_places"`
+
+	// EstimateLimit and EstimateSortBy shape the estimates of
+	// requests whose API key has no estimate defaults saved.
+	EstimateLimit  int    `json:"estimate_limit"`
+	EstimateSortBy string `json:"estimate_sort_by"`
+
+	// OTLPEndpoint if set is the OTLP/HTTP collector, e.g.
+	// "http://localhost:4318", that traces are exported to.
+	// TraceSampleRatio is the share of new traces sampled.
+	OTLPEndpoint     string  `json:"otlp_endpoint"`
+	TraceSampleRatio float64 `json:"trace_sample_ratio"`
+
+	// LogLevel is one of "debug", "info", "warn" or "error"
+	// and LogFormat is either "text" or "json".
+	LogLevel  string `json:"log_level"`
+	LogFormat string `json:"log_format"`
+}
+
+// Duration is a time.Duration that is written
+// in configuration files as a string e.g. "30s".
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	return d.Set(s)
+}
+
+// Set parses s and makes Duration usable as a flag.Value.
+func (d *Duration) Set(s string) error {
+	dur, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = dur
+	return nil
+}
+
+const (
+	StoreRedis = "redis"
+	StoreFile  = "file"
+)
+
+func Default() *Config {
+	return &Config{
+		Store:        StoreRedis,
+		HTTPAddr:     ":9899",
+		RedirectAddr: ":80",
+		RedirectURL:  "https://uberclick.orijtech.com",
+		MetricsAddr:  "localhost:9900",
+		Domains: []string{
+			"uberclick.orijtech.com",
+			"www.uberclick.orijtech.com",
+		},
+		StaticDir:        "./static",
+		DrainDelay:       Duration{5 * time.Second},
+		ShutdownTimeout:  Duration{30 * time.Second},
+		StoreTimeout:     Duration{2 * time.Second},
+		UpstreamTimeout:  Duration{10 * time.Second},
+		EstimateCacheTTL: Duration{30 * time.Second},
+		ProductsCacheTTL: Duration{10 * time.Minute},
+		EstimateLimit:    uberclick.DefaultEstimateLimit,
+		EstimateSortBy:   string(uberclick.DefaultSortKey),
+		TraceSampleRatio: 1,
+		LogLevel:         "info",
+		LogFormat:        logging.FormatText,
+	}
+}
+
+// Load returns the default configuration overlaid with the
+// contents of the JSON file at path, if path is non-empty,
+// and then with any UBERCLICK_* environment variables.
+func Load(path string) (*Config, error) {
+	cfg := Default()
+	if path != "" {
+		blob, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(blob, cfg); err != nil {
+			return nil, fmt.Errorf("config: parsing %q: %v", path, err)
+		}
+	}
+	if err := cfg.applyEnv(os.LookupEnv); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (cfg *Config) applyEnv(lookup func(string) (string, bool)) error {
+	strs := map[string]*string{
+		"UBERCLICK_HTTP_ADDR":            &cfg.HTTPAddr,
+		"UBERCLICK_REDIRECT_ADDR":        &cfg.RedirectAddr,
+		"UBERCLICK_REDIRECT_URL":         &cfg.RedirectURL,
+		"UBERCLICK_METRICS_ADDR":         &cfg.MetricsAddr,
+		"UBERCLICK_STATIC_DIR":           &cfg.StaticDir,
+		"UBERCLICK_STORE":                &cfg.Store,
+		"UBERCLICK_STORE_PATH":           &cfg.StorePath,
+		"UBERCLICK_REDIS_SERVER_URL":     &cfg.RedisServerURL,
+		"UBERCLICK_OAUTH2_CLIENT_ID":     &cfg.OAuth2ClientID,
+		"UBERCLICK_OAUTH2_CLIENT_SECRET": &cfg.OAuth2ClientSecret,
+		"UBERCLICK_LOG_LEVEL":            &cfg.LogLevel,
+		"UBERCLICK_LOG_FORMAT":           &cfg.LogFormat,
+		"UBERCLICK_ESTIMATE_SORT_BY":     &cfg.EstimateSortBy,
+		"UBERCLICK_GEOCODER_PLACES":      &cfg.GeocoderPlaces,
+		"UBERCLICK_OTLP_ENDPOINT":        &cfg.OTLPEndpoint,
+	}
+	for name, ptr := range strs {
+		if v, ok := lookup(name); ok {
+			*ptr = v
+		}
+	}
+	if v, ok := lookup("UBERCLICK_DOMAINS"); ok {
+		cfg.Domains = splitList(v)
+	}
+	durations := map[string]*Duration{
+		"UBERCLICK_DRAIN_DELAY":        &cfg.DrainDelay,
+		"UBERCLICK_SHUTDOWN_TIMEOUT":   &cfg.ShutdownTimeout,
+		"UBERCLICK_STORE_TIMEOUT":      &cfg.StoreTimeout,
+		"UBERCLICK_UPSTREAM_TIMEOUT":   &cfg.UpstreamTimeout,
+		"UBERCLICK_ESTIMATE_CACHE_TTL": &cfg.EstimateCacheTTL,
+		"UBERCLICK_PRODUCTS_CACHE_TTL": &cfg.ProductsCacheTTL,
+	}
+	for name, ptr := range durations {
+		if v, ok := lookup(name); ok {
+			if err := ptr.Set(v); err != nil {
+				return fmt.Errorf("config: %s: %v", name, err)
+			}
+		}
+	}
+	if v, ok := lookup("UBERCLICK_ESTIMATE_LIMIT"); ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("config: UBERCLICK_ESTIMATE_LIMIT: %v", err)
+		}
+		cfg.EstimateLimit = n
+	}
+	if v, ok := lookup("UBERCLICK_TRACE_SAMPLE_RATIO"); ok {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return fmt.Errorf("config: UBERCLICK_TRACE_SAMPLE_RATIO: %v", err)
+		}
+		cfg.TraceSampleRatio = f
+	}
+	if v, ok := lookup("UBERCLICK_HTTP1"); ok {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("config: UBERCLICK_HTTP1: %v", err)
+		}
+		cfg.HTTP1 = b
+	}
+	return nil
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
+// Flags registers the command line flags on fs and returns a function
+// that applies, after fs has been parsed, only the flags that were
+// explicitly set so that they take precedence over the file and env.
+func Flags(fs *flag.FlagSet) func(*Config) {
+	var fcfg Config
+	var domains string
+	fs.BoolVar(&fcfg.HTTP1, "http1", false, "if set runs the server in HTTP1 mode")
+	fs.StringVar(&fcfg.HTTPAddr, "http-addr", "", "the address to serve on in HTTP1 mode")
+	fs.StringVar(&fcfg.RedirectAddr, "redirect-addr", "", "the address whose traffic is redirected to HTTPS")
+	fs.StringVar(&fcfg.RedirectURL, "redirect-url", "", "the URL that non-HTTPS traffic is redirected to")
+	fs.StringVar(&domains, "domains", "", "comma separated domains to provision TLS certificates for")
+	fs.StringVar(&fcfg.MetricsAddr, "metrics-addr", "", "the address that Prometheus metrics are served on, blank to not serve them")
+	fs.StringVar(&fcfg.StaticDir, "static-dir", "", "the directory of static files to serve")
+	fs.StringVar(&fcfg.Store, "store", "", `the storage backend, either "redis" or "file"`)
+	fs.StringVar(&fcfg.StorePath, "store-path", "", "the path of the file used by the file storage backend")
+	fs.StringVar(&fcfg.RedisServerURL, "redis-server-url", "", "the URL of the Redis server")
+	fs.Var(&fcfg.DrainDelay, "drain-delay", "how long readiness checks fail on shutdown before new connections are refused")
+	fs.Var(&fcfg.ShutdownTimeout, "shutdown-timeout", "how long in-flight requests are given to drain on shutdown")
+	fs.Var(&fcfg.StoreTimeout, "store-timeout", "the deadline of each store operation")
+	fs.Var(&fcfg.UpstreamTimeout, "upstream-timeout", "the deadline of each call to the Uber API")
+	fs.Var(&fcfg.EstimateCacheTTL, "estimate-cache-ttl", "how long the estimates of a trip are reused, negative to disable")
+	fs.Var(&fcfg.ProductsCacheTTL, "products-cache-ttl", "how long the products offered at a location are reused, negative to disable")
+	fs.StringVar(&fcfg.GeocoderPlaces, "geocoder-places", "", "the path of a JSON array of places that addresses are resolved against")
+	fs.IntVar(&fcfg.EstimateLimit, "estimate-limit", 0, "how many estimates are returned by default")
+	fs.StringVar(&fcfg.EstimateSortBy, "estimate-sort-by", "", `the default order of estimates, one of "price", "pickup_eta", "duration" or "capacity"`)
+	fs.StringVar(&fcfg.OTLPEndpoint, "otlp-endpoint", "", "the OTLP/HTTP collector that traces are exported to")
+	fs.Float64Var(&fcfg.TraceSampleRatio, "trace-sample-ratio", 0, "the share of new traces that are sampled, between 0 and 1")
+	fs.StringVar(&fcfg.LogLevel, "log-level", "", `the minimum level logged, one of "debug", "info", "warn" or "error"`)
+	fs.StringVar(&fcfg.LogFormat, "log-format", "", `the format of logs, either "text" or "json"`)
+
+	return func(cfg *Config) {
+		fs.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "http1":
+				cfg.HTTP1 = fcfg.HTTP1
+			case "http-addr":
+				cfg.HTTPAddr = fcfg.HTTPAddr
+			case "redirect-addr":
+				cfg.RedirectAddr = fcfg.RedirectAddr
+			case "redirect-url":
+				cfg.RedirectURL = fcfg.RedirectURL
+			case "domains":
+				cfg.Domains = splitList(domains)
+			case "metrics-addr":
+				cfg.MetricsAddr = fcfg.MetricsAddr
+			case "static-dir":
+				cfg.StaticDir = fcfg.StaticDir
+			case "store":
+				cfg.Store = fcfg.Store
+			case "store-path":
+				cfg.StorePath = fcfg.StorePath
+			case "redis-server-url":
+				cfg.RedisServerURL = fcfg.RedisServerURL
+			case "drain-delay":
+				cfg.DrainDelay = fcfg.DrainDelay
+			case "shutdown-timeout":
+				cfg.ShutdownTimeout = fcfg.ShutdownTimeout
+			case "store-timeout":
+				cfg.StoreTimeout = fcfg.StoreTimeout
+			case "upstream-timeout":
+				cfg.UpstreamTimeout = fcfg.UpstreamTimeout
+			case "estimate-cache-ttl":
+				cfg.EstimateCacheTTL = fcfg.EstimateCacheTTL
+			case "products-cache-ttl":
+				cfg.ProductsCacheTTL = fcfg.ProductsCacheTTL
+			case "geocoder-places":
+				cfg.GeocoderPlaces = fcfg.GeocoderPlaces
+			case "estimate-limit":
+				cfg.EstimateLimit = fcfg.EstimateLimit
+			case "estimate-sort-by":
+				cfg.EstimateSortBy = fcfg.EstimateSortBy
+			case "otlp-endpoint":
+				cfg.OTLPEndpoint = fcfg.OTLPEndpoint
+			case "trace-sample-ratio":
+				cfg.TraceSampleRatio = fcfg.TraceSampleRatio
+			case "log-level":
+				cfg.LogLevel = fcfg.LogLevel
+			case "log-format":
+				cfg.LogFormat = fcfg.LogFormat
+			}
+		})
+	}
+}
+
+var redisSchemes = map[string]bool{
+	"redis":           true,
+	"rediss":          true,
+	"redis-sentinel":  true,
+	"rediss-sentinel": true,
+	"redis-cluster":   true,
+	"rediss-cluster":  true,
+}
+
+// Validate checks the whole configuration and reports
+// every problem found rather than stopping at the first.
+func (cfg *Config) Validate() error {
+	var errs []error
+	addErr := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	switch cfg.Store {
+	case StoreRedis:
+		if cfg.RedisServerURL == "" {
+			addErr("redis_server_url: expecting a non-blank URL")
+		} else if u, err := url.Parse(cfg.RedisServerURL); err != nil {
+			addErr("redis_server_url: %v", err)
+		} else if !redisSchemes[u.Scheme] {
+			addErr("redis_server_url: unsupported scheme %q", u.Scheme)
+		}
+	case StoreFile:
+		if cfg.StorePath == "" {
+			addErr("store_path: expecting a non-blank path for the file store")
+		}
+	default:
+		addErr("store: expecting %q or %q, got %q", StoreRedis, StoreFile, cfg.Store)
+	}
+	if cfg.OAuth2ClientID == "" {
+		addErr("oauth2_client_id: expecting a non-blank client ID")
+	}
+	if cfg.OAuth2ClientSecret == "" {
+		addErr("oauth2_client_secret: expecting a non-blank client secret")
+	}
+
+	if cfg.GeocoderPlaces != "" {
+		if _, err := os.Stat(cfg.GeocoderPlaces); err != nil {
+			addErr("geocoder_places: %v", err)
+		}
+	}
+
+	if cfg.DrainDelay.Duration < 0 {
+		addErr("drain_delay: expecting a non-negative duration, got %v", cfg.DrainDelay)
+	}
+	if cfg.ShutdownTimeout.Duration < 0 {
+		addErr("shutdown_timeout: expecting a non-negative duration, got %v", cfg.ShutdownTimeout)
+	}
+	if cfg.StoreTimeout.Duration <= 0 {
+		addErr("store_timeout: expecting a positive duration, got %v", cfg.StoreTimeout)
+	}
+	if cfg.UpstreamTimeout.Duration <= 0 {
+		addErr("upstream_timeout: expecting a positive duration, got %v", cfg.UpstreamTimeout)
+	}
+
+	if cfg.EstimateLimit < 1 || cfg.EstimateLimit > uberclick.MaxEstimateLimit {
+		addErr("estimate_limit: expecting between 1 and %d, got %d", uberclick.MaxEstimateLimit, cfg.EstimateLimit)
+	}
+	eo := &uberclick.EstimateOptions{SortBy: uberclick.SortKey(cfg.EstimateSortBy)}
+	if cfg.EstimateSortBy == "" || eo.Validate() != nil {
+		addErr("estimate_sort_by: %s, got %q", uberclick.ErrInvalidSortKey.Details, cfg.EstimateSortBy)
+	}
+
+	if cfg.OTLPEndpoint != "" {
+		if u, err := url.Parse(cfg.OTLPEndpoint); err != nil || u.Host == "" {
+			addErr("otlp_endpoint: expecting an absolute URL, got %q", cfg.OTLPEndpoint)
+		}
+	}
+	if cfg.TraceSampleRatio < 0 || cfg.TraceSampleRatio > 1 {
+		addErr("trace_sample_ratio: expecting between 0 and 1, got %v", cfg.TraceSampleRatio)
+	}
+
+	if _, err := logging.ParseLevel(cfg.LogLevel); err != nil {
+		addErr("log_level: %v", err)
+	}
+	if cfg.LogFormat != logging.FormatText && cfg.LogFormat != logging.FormatJSON {
+		addErr("log_format: expecting %q or %q, got %q", logging.FormatText, logging.FormatJSON, cfg.LogFormat)
+	}
+
+	if cfg.HTTP1 {
+		if cfg.HTTPAddr == "" {
+			addErr("http_addr: expecting a non-blank address in HTTP1 mode")
+		}
+	} else {
+		if len(cfg.Domains) == 0 {
+			addErr("domains: expecting at least one domain for TLS")
+		}
+		if cfg.RedirectAddr != "" {
+			if u, err := url.Parse(cfg.RedirectURL); err != nil || u.Host == "" {
+				addErr("redirect_url: expecting an absolute URL, got %q", cfg.RedirectURL)
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
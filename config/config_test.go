@@ -0,0 +1,290 @@
+package config_test
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/odeke-em/uberclick/config"
+)
+
+// writeConfig saves blob as a configuration file and returns its path.
+func writeConfig(t *testing.T, blob string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "uberclick.json")
+	if err := os.WriteFile(path, []byte(blob), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	path := writeConfig(t, `{"http_addr": ":8080", "static_dir": "/srv/static", "redis_server_url": "redis://file:6379", "store_timeout": "5s", "domains": ["a.example.com"]}`)
+	t.Setenv("UBERCLICK_STATIC_DIR", "/mnt/static")
+	t.Setenv("UBERCLICK_DOMAINS", "b.example.com, c.example.com")
+
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.HTTPAddr != ":8080" {
+		t.Errorf("http_addr = %q, want the file's %q", cfg.HTTPAddr, ":8080")
+	}
+	if cfg.RedisServerURL != "redis://file:6379" {
+		t.Errorf("redis_server_url = %q, want the file's", cfg.RedisServerURL)
+	}
+	if cfg.StoreTimeout.Duration != 5*time.Second {
+		t.Errorf("store_timeout = %v, want the file's 5s", cfg.StoreTimeout)
+	}
+	if cfg.StaticDir != "/mnt/static" {
+		t.Errorf("static_dir = %q, want the env's %q", cfg.StaticDir, "/mnt/static")
+	}
+	if got := strings.Join(cfg.Domains, ","); got != "b.example.com,c.example.com" {
+		t.Errorf("domains = %q, want the env's", got)
+	}
+	if want := config.Default().RedirectURL; cfg.RedirectURL != want {
+		t.Errorf("redirect_url = %q, want the default %q", cfg.RedirectURL, want)
+	}
+	if want := config.Default().UpstreamTimeout; cfg.UpstreamTimeout != want {
+		t.Errorf("upstream_timeout = %v, want the default %v", cfg.UpstreamTimeout, want)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		blob string
+		env  map[string]string
+	}{
+		{name: "malformed file", blob: `{"http_addr": `},
+		{name: "malformed duration in file", blob: `{"store_timeout": "soon"}`},
+		{name: "malformed duration in env", env: map[string]string{"UBERCLICK_STORE_TIMEOUT": "soon"}},
+		{name: "malformed bool in env", env: map[string]string{"UBERCLICK_HTTP1": "maybe"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := ""
+			if tt.blob != "" {
+				path = writeConfig(t, tt.blob)
+			}
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			if _, err := config.Load(path); err == nil {
+				t.Fatal("Load succeeded, want an error")
+			}
+		})
+	}
+
+	if _, err := config.Load(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("Load of a missing file succeeded, want an error")
+	}
+}
+
+func TestFlags(t *testing.T) {
+	path := writeConfig(t, `{"http_addr": ":8080", "redirect_addr": ":8081", "redis_server_url": "redis://file:6379", "shutdown_timeout": "1m"}`)
+	t.Setenv("UBERCLICK_REDIRECT_ADDR", ":8082")
+	t.Setenv("UBERCLICK_REDIS_SERVER_URL", "redis://env:6379")
+	t.Setenv("UBERCLICK_STORE_TIMEOUT", "3s")
+
+	fs := flag.NewFlagSet("uberclick", flag.ContinueOnError)
+	apply := config.Flags(fs)
+	if err := fs.Parse([]string{"--redirect-addr=:8083", "--redis-server-url=redis://flag:6379", "--store-timeout=4s", "--http1", "--domains=d.example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	apply(cfg)
+
+	if cfg.HTTPAddr != ":8080" {
+		t.Errorf("http_addr = %q, want the file's %q as the flag was not set", cfg.HTTPAddr, ":8080")
+	}
+	if cfg.ShutdownTimeout.Duration != time.Minute {
+		t.Errorf("shutdown_timeout = %v, want the file's 1m as the flag was not set", cfg.ShutdownTimeout)
+	}
+	if cfg.RedirectAddr != ":8083" {
+		t.Errorf("redirect_addr = %q, want the flag's %q over the file and env", cfg.RedirectAddr, ":8083")
+	}
+	if cfg.RedisServerURL != "redis://flag:6379" {
+		t.Errorf("redis_server_url = %q, want the flag's over the env", cfg.RedisServerURL)
+	}
+	if cfg.StoreTimeout.Duration != 4*time.Second {
+		t.Errorf("store_timeout = %v, want the flag's 4s over the env", cfg.StoreTimeout)
+	}
+	if !cfg.HTTP1 {
+		t.Error("http1 = false, want the flag's true")
+	}
+	if len(cfg.Domains) != 1 || cfg.Domains[0] != "d.example.com" {
+		t.Errorf("domains = %q, want the flag's", cfg.Domains)
+	}
+}
+
+// validConfig returns a configuration that passes Validate.
+func validConfig() *config.Config {
+	cfg := config.Default()
+	cfg.RedisServerURL = "redis://localhost:6379"
+	cfg.OAuth2ClientID = "client-id"
+	cfg.OAuth2ClientSecret = "client-secret"
+	return cfg
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*config.Config)
+		// want are the settings expected to be reported.
+		want []string
+	}{
+		{name: "valid"},
+		{
+			name:   "static dir not mounted yet",
+			modify: func(cfg *config.Config) { cfg.StaticDir = "/does/not/exist/yet" },
+		},
+		{
+			name:   "no static dir",
+			modify: func(cfg *config.Config) { cfg.StaticDir = "" },
+		},
+		{
+			name:   "unknown store",
+			modify: func(cfg *config.Config) { cfg.Store = "sqlite" },
+			want:   []string{"store:"},
+		},
+		{
+			name:   "blank redis URL",
+			modify: func(cfg *config.Config) { cfg.RedisServerURL = "" },
+			want:   []string{"redis_server_url:"},
+		},
+		{
+			name:   "redis cluster over TLS",
+			modify: func(cfg *config.Config) { cfg.RedisServerURL = "rediss-cluster://:secret@10.0.0.1:7000,10.0.0.2:7001" },
+		},
+		{
+			name:   "unsupported redis scheme",
+			modify: func(cfg *config.Config) { cfg.RedisServerURL = "http://localhost:6379" },
+			want:   []string{"redis_server_url:"},
+		},
+		{
+			name: "file store",
+			modify: func(cfg *config.Config) {
+				cfg.Store = config.StoreFile
+				cfg.StorePath = "/var/lib/uberclick/store"
+				cfg.RedisServerURL = ""
+			},
+		},
+		{
+			name:   "file store without a path",
+			modify: func(cfg *config.Config) { cfg.Store = config.StoreFile },
+			want:   []string{"store_path:"},
+		},
+		{
+			name: "no OAuth2.0 app",
+			modify: func(cfg *config.Config) {
+				cfg.OAuth2ClientID = ""
+				cfg.OAuth2ClientSecret = ""
+			},
+			want: []string{"oauth2_client_id:", "oauth2_client_secret:"},
+		},
+		{
+			name: "bad timeouts",
+			modify: func(cfg *config.Config) {
+				cfg.ShutdownTimeout.Duration = -time.Second
+				cfg.StoreTimeout.Duration = 0
+				cfg.UpstreamTimeout.Duration = -time.Second
+			},
+			want: []string{"shutdown_timeout:", "store_timeout:", "upstream_timeout:"},
+		},
+		{
+			name:   "negative drain delay",
+			modify: func(cfg *config.Config) { cfg.DrainDelay.Duration = -time.Second },
+			want:   []string{"drain_delay:"},
+		},
+		{
+			name:   "missing geocoder places",
+			modify: func(cfg *config.Config) { cfg.GeocoderPlaces = "/does/not/exist.json" },
+			want:   []string{"geocoder_places:"},
+		},
+		{
+			name: "bad estimate defaults",
+			modify: func(cfg *config.Config) {
+				cfg.EstimateLimit = 0
+				cfg.EstimateSortBy = "colour"
+			},
+			want: []string{"estimate_limit:", "estimate_sort_by:"},
+		},
+		{
+			name: "bad tracing",
+			modify: func(cfg *config.Config) {
+				cfg.OTLPEndpoint = "localhost:4318"
+				cfg.TraceSampleRatio = 1.5
+			},
+			want: []string{"otlp_endpoint:", "trace_sample_ratio:"},
+		},
+		{
+			name: "bad logging",
+			modify: func(cfg *config.Config) {
+				cfg.LogLevel = "loud"
+				cfg.LogFormat = "xml"
+			},
+			want: []string{"log_level:", "log_format:"},
+		},
+		{
+			name:   "TLS without domains",
+			modify: func(cfg *config.Config) { cfg.Domains = nil },
+			want:   []string{"domains:"},
+		},
+		{
+			name:   "relative redirect URL",
+			modify: func(cfg *config.Config) { cfg.RedirectURL = "/elsewhere" },
+			want:   []string{"redirect_url:"},
+		},
+		{
+			name: "HTTP1 ignores TLS settings",
+			modify: func(cfg *config.Config) {
+				cfg.HTTP1 = true
+				cfg.Domains = nil
+				cfg.RedirectURL = ""
+			},
+		},
+		{
+			name: "HTTP1 without an address",
+			modify: func(cfg *config.Config) {
+				cfg.HTTP1 = true
+				cfg.HTTPAddr = ""
+			},
+			want: []string{"http_addr:"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := validConfig()
+			if tt.modify != nil {
+				tt.modify(cfg)
+			}
+			err := cfg.Validate()
+			if len(tt.want) == 0 {
+				if err != nil {
+					t.Fatalf("Validate: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("Validate succeeded, want errors for %q", tt.want)
+			}
+			lines := strings.Split(err.Error(), "\n")
+			if len(lines) != len(tt.want) {
+				t.Fatalf("Validate reported %d problems, want %d: %v", len(lines), len(tt.want), err)
+			}
+			for i, prefix := range tt.want {
+				if !strings.HasPrefix(lines[i], prefix) {
+					t.Errorf("problem %d = %q, want it to start with %q", i, lines[i], prefix)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,220 @@
+package uberclick
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Code is a stable machine-readable identifier for a kind
+// of error that clients can branch on instead of Reason.
+type Code string
+
+// The catalog of codes, which every Err carries one of.
+const (
+	CodeInvalidSubmission Code = "invalid_submission"
+	CodeInvalidBody       Code = "invalid_body"
+	CodeBodyTooLarge      Code = "body_too_large"
+	CodeUnsupportedMedia  Code = "unsupported_media_type"
+	CodeInvalidNonce      Code = "invalid_nonce"
+	CodeNonceReplayed     Code = "nonce_replayed"
+	CodeUnknownNonce      Code = "unknown_nonce"
+	CodeInvalidAPIKey     Code = "invalid_api_key"
+	CodeUnknownAPIKey     Code = "unknown_api_key"
+	CodeInvalidOrigin     Code = "invalid_origin"
+	CodeDomainNotAllowed  Code = "domain_not_allowed"
+	CodeInvalidState      Code = "invalid_state"
+	CodeExchangeFailed    Code = "oauth2_exchange_failed"
+	CodeUnauthenticated   Code = "unauthenticated"
+	CodeTokenExpired      Code = "token_expired"
+
+	CodeInvalidCoordinates  Code = "invalid_coordinates"
+	CodeMissingLocation     Code = "missing_location"
+	CodeConflictingLocation Code = "conflicting_location"
+	CodeIdenticalEndpoints  Code = "identical_endpoints"
+	CodeTripTooLong         Code = "trip_too_long"
+	CodeInvalidSeatCount    Code = "invalid_seat_count"
+
+	CodeInvalidLimit       Code = "invalid_limit"
+	CodeInvalidSortKey     Code = "invalid_sort_key"
+	CodeInvalidProductType Code = "invalid_product_type"
+
+	CodeInvalidPlace         Code = "invalid_place"
+	CodeInvalidAddress       Code = "invalid_address"
+	CodeMethodNotAllowed     Code = "method_not_allowed"
+	CodeInvalidQuery         Code = "invalid_query"
+	CodePlaceNotFound        Code = "place_not_found"
+	CodeUnlocatablePlace     Code = "unlocatable_place"
+	CodeGeocodingUnavailable Code = "geocoding_unavailable"
+
+	CodeStoreUnavailable    Code = "store_unavailable"
+	CodeUpstreamError       Code = "upstream_error"
+	CodeUpstreamUnavailable Code = "upstream_unavailable"
+	CodeUpstreamTimeout     Code = "upstream_timeout"
+	CodeInternal            Code = "internal_error"
+)
+
+// The catalog of errors. Any *Err with the same Code
+// matches these, so errors.Is(err, ErrInvalidNonce)
+// holds regardless of Details or Meta.
+var (
+	ErrInvalidSubmission = &Err{
+		Code:    CodeInvalidSubmission,
+		Reason:  "invalid data",
+		Details: "expecting a non-blank submission",
+	}
+	ErrInvalidBody = &Err{
+		Code:    CodeInvalidBody,
+		Reason:  "invalid request body",
+		Details: "expecting a well formed JSON body",
+	}
+	ErrBodyTooLarge = &Err{
+		Code:    CodeBodyTooLarge,
+		Reason:  "request body too large",
+		Details: "the request body exceeds the allowed size",
+	}
+	ErrUnsupportedMedia = &Err{
+		Code:    CodeUnsupportedMedia,
+		Reason:  "unsupported content type",
+		Details: "expecting Content-Type: application/json",
+	}
+	ErrInvalidNonce = &Err{
+		Code:    CodeInvalidNonce,
+		Reason:  "invalid/blank nonce",
+		Details: "expecting a valid nonce",
+	}
+	ErrNonceReplayed = &Err{
+		Code:    CodeNonceReplayed,
+		Reason:  "replayed nonce",
+		Details: "this authorization was already completed",
+	}
+	ErrUnknownNonce = &Err{
+		Code:    CodeUnknownNonce,
+		Reason:  "unknown nonce",
+		Details: "no authorization exists for this nonce",
+	}
+	ErrInvalidAPIKey = &Err{
+		Code:    CodeInvalidAPIKey,
+		Reason:  "invalid/blank apiKey",
+		Details: "expecting a valid apiKey",
+	}
+	ErrUnknownAPIKey = &Err{
+		Code:    CodeUnknownAPIKey,
+		Reason:  "unknown apiKey",
+		Details: "no domains are registered for this apiKey",
+	}
+	ErrInvalidOrigin = &Err{
+		Code:    CodeInvalidOrigin,
+		Reason:  "invalid origin",
+		Details: "expecting the origin to be a valid URL",
+	}
+	ErrDomainNotAllowed = &Err{
+		Code:    CodeDomainNotAllowed,
+		Reason:  "unauthorized domain",
+		Details: "the origin is not registered for this apiKey",
+	}
+	ErrInvalidState = &Err{
+		Code:    CodeInvalidState,
+		Reason:  "invalid/expired state",
+		Details: "failed to correlate the found state. Please try again",
+	}
+	ErrExchangeFailed = &Err{
+		Code:    CodeExchangeFailed,
+		Reason:  "authorization failed",
+		Details: "the authorization code was rejected. Please try again",
+	}
+	ErrUnauthenticated = &Err{
+		Code:    CodeUnauthenticated,
+		Reason:  "not authorized",
+		Details: "no authorization exists for this session. Please grant access",
+	}
+	ErrTokenExpired = &Err{
+		Code:    CodeTokenExpired,
+		Reason:  "expired authorization",
+		Details: "the authorization has expired. Please grant access again",
+	}
+	ErrStoreUnavailable = &Err{
+		Code:    CodeStoreUnavailable,
+		Reason:  "temporarily unavailable",
+		Details: "storage is temporarily unavailable. Please retry",
+	}
+	ErrUpstream = &Err{
+		Code:    CodeUpstreamError,
+		Reason:  "upstream failure",
+		Details: "the Uber API failed to fulfil the request",
+	}
+	ErrUpstreamUnavailable = &Err{
+		Code:    CodeUpstreamUnavailable,
+		Reason:  "upstream unavailable",
+		Details: "the Uber API could not be reached. Please retry",
+	}
+	ErrUpstreamTimeout = &Err{
+		Code:    CodeUpstreamTimeout,
+		Reason:  "upstream timeout",
+		Details: "the Uber API took too long to respond",
+	}
+	ErrInternal = &Err{
+		Code:    CodeInternal,
+		Reason:  "internal error",
+		Details: "an unexpected error occurred",
+	}
+)
+
+var _ error = (*Err)(nil)
+
+func (e *Err) Error() string {
+	var parts []string
+	var field string
+	if e.Field != "" {
+		field = strconv.Quote(e.Field)
+	}
+	for _, s := range []string{string(e.Code), field, e.Reason, e.Details} {
+		if s != "" {
+			parts = append(parts, s)
+		}
+	}
+	return strings.Join(parts, ": ")
+}
+
+// Is reports whether target is an *Err with the same Code.
+func (e *Err) Is(target error) bool {
+	te, ok := target.(*Err)
+	if !ok || te == nil {
+		return false
+	}
+	if e.Code == "" || te.Code == "" {
+		return e == te
+	}
+	return e.Code == te.Code
+}
+
+// WithMeta returns a copy of e carrying meta.
+func (e *Err) WithMeta(meta interface{}) *Err {
+	ec := *e
+	ec.Meta = meta
+	return &ec
+}
+
+var _ error = (*WrappedError)(nil)
+
+func (we *WrappedError) Error() string {
+	var msgs []string
+	for _, e := range we.Errors {
+		msgs = append(msgs, e.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Unwrap lets errors.Is and errors.As see each of the wrapped errors.
+func (we *WrappedError) Unwrap() []error {
+	errs := make([]error, 0, len(we.Errors))
+	for _, e := range we.Errors {
+		errs = append(errs, e)
+	}
+	return errs
+}
+
+// HasCode reports whether err is, or wraps, an *Err with code.
+func HasCode(err error, code Code) bool {
+	return errors.Is(err, &Err{Code: code})
+}
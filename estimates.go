@@ -0,0 +1,182 @@
+package uberclick
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/odeke-em/uberclick/store"
+)
+
+// SortKey is what estimates are ordered by.
+type SortKey string
+
+const (
+	// SortByPrice orders estimates from the cheapest low estimate,
+	// whether or not they have an upfront fare.
+	SortByPrice SortKey = "price"
+	// SortByPickupETA orders estimates from the soonest pickup.
+	SortByPickupETA SortKey = "pickup_eta"
+	// SortByDuration orders estimates from the shortest trip.
+	SortByDuration SortKey = "duration"
+	// SortByCapacity orders estimates from the roomiest product.
+	SortByCapacity SortKey = "capacity"
+)
+
+var sortKeys = map[SortKey]bool{
+	SortByPrice:     true,
+	SortByPickupETA: true,
+	SortByDuration:  true,
+	SortByCapacity:  true,
+}
+
+const (
+	// DefaultEstimateLimit is how many estimates are
+	// returned unless the caller or its API key asks
+	// for some other number.
+	DefaultEstimateLimit = 4
+	MaxEstimateLimit     = 20
+
+	DefaultSortKey = SortByPrice
+)
+
+var (
+	ErrInvalidLimit = &Err{
+		Code:    CodeInvalidLimit,
+		Reason:  "invalid limit",
+		Details: fmt.Sprintf("expecting a limit between 1 and %d", MaxEstimateLimit),
+	}
+	ErrInvalidSortKey = &Err{
+		Code:    CodeInvalidSortKey,
+		Reason:  "invalid sort key",
+		Details: fmt.Sprintf("expecting one of %q, %q, %q or %q", SortByPrice, SortByPickupETA, SortByDuration, SortByCapacity),
+	}
+	ErrInvalidProductType = &Err{
+		Code:    CodeInvalidProductType,
+		Reason:  "invalid product type",
+		Details: "expecting a non-blank product type",
+	}
+)
+
+// EstimateOptions shape the estimates returned for a trip.
+// Unset fields fall back to the defaults of the API key
+// and then to those of the server.
+type EstimateOptions struct {
+	// Limit is the maximum number of estimates returned.
+	Limit int `json:"limit,omitempty"`
+
+	SortBy SortKey `json:"sort_by,omitempty"`
+
+	// ProductTypes if set keeps only the products whose group,
+	// such as "uberx" or "rideshare", or whose display name
+	// is one of them, regardless of case.
+	ProductTypes []string `json:"product_types,omitempty"`
+
+	// PickupTimes if true adds to each estimate how long the
+	// product takes to arrive at the start of the trip. It is
+	// a pointer so that false can override defaults of true.
+	PickupTimes *bool `json:"pickup_times,omitempty"`
+}
+
+func (eo *EstimateOptions) Validate() (we *WrappedError) {
+	var errsList []*Err
+
+	defer func() {
+		if len(errsList) > 0 {
+			we = &WrappedError{Errors: errsList}
+		}
+	}()
+
+	if eo == nil {
+		return
+	}
+	if eo.Limit < 0 || eo.Limit > MaxEstimateLimit {
+		errsList = append(errsList, fieldErr(ErrInvalidLimit, "limit"))
+	}
+	if eo.SortBy != "" && !sortKeys[eo.SortBy] {
+		errsList = append(errsList, fieldErr(ErrInvalidSortKey, "sort_by"))
+	}
+	for _, pt := range eo.ProductTypes {
+		if strings.TrimSpace(pt) == "" {
+			errsList = append(errsList, fieldErr(ErrInvalidProductType, "product_types"))
+			break
+		}
+	}
+	return
+}
+
+// Or returns the options of eo with every unset
+// field taken from defaults instead, if any.
+func (eo *EstimateOptions) Or(defaults *EstimateOptions) *EstimateOptions {
+	merged := new(EstimateOptions)
+	if eo != nil {
+		*merged = *eo
+	}
+	if defaults == nil {
+		return merged
+	}
+	if merged.Limit == 0 {
+		merged.Limit = defaults.Limit
+	}
+	if merged.SortBy == "" {
+		merged.SortBy = defaults.SortBy
+	}
+	if len(merged.ProductTypes) == 0 {
+		merged.ProductTypes = defaults.ProductTypes
+	}
+	if merged.PickupTimes == nil {
+		merged.PickupTimes = defaults.PickupTimes
+	}
+	return merged
+}
+
+// AddsPickupTimes reports whether eo asks for pickup times.
+func (eo *EstimateOptions) AddsPickupTimes() bool {
+	return eo.PickupTimes != nil && *eo.PickupTimes
+}
+
+// HasProductType reports whether a product of the given group
+// and display name passes the ProductTypes filter of eo.
+func (eo *EstimateOptions) HasProductType(group, displayName string) bool {
+	if len(eo.ProductTypes) == 0 {
+		return true
+	}
+	for _, pt := range eo.ProductTypes {
+		pt = strings.TrimSpace(pt)
+		if strings.EqualFold(pt, group) || strings.EqualFold(pt, displayName) {
+			return true
+		}
+	}
+	return false
+}
+
+const estimateDefaultsTable = "api-key-estimate-defaults"
+
+// SetEstimateDefaults saves the options applied to the
+// estimates of every request made with the API key.
+func (reg *RedisAPIKeyRegistration) SetEstimateDefaults(ctx context.Context, st store.Store, eo *EstimateOptions) error {
+	blob, err := json.Marshal(eo)
+	if err != nil {
+		return err
+	}
+	return st.HSet(ctx, estimateDefaultsTable, reg.APIKey, blob)
+}
+
+// EstimateDefaults returns the options saved for the
+// API key, or nil if none were ever saved.
+func (reg *RedisAPIKeyRegistration) EstimateDefaults(ctx context.Context, st store.Store) (*EstimateOptions, error) {
+	blob, err := st.HGet(ctx, estimateDefaultsTable, reg.APIKey)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	eo := new(EstimateOptions)
+	if err := json.Unmarshal(blob, eo); err != nil {
+		return nil, err
+	}
+	return eo, nil
+}
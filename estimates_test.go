@@ -0,0 +1,37 @@
+package uberclick_test
+
+import (
+	"testing"
+
+	"github.com/odeke-em/uberclick"
+)
+
+func TestEstimateOptionsOr(t *testing.T) {
+	on, off := true, false
+	defaults := &uberclick.EstimateOptions{Limit: 5, SortBy: uberclick.SortByPrice, PickupTimes: &on}
+
+	tests := []struct {
+		name      string
+		eo        *uberclick.EstimateOptions
+		wantLimit int
+		wantSort  uberclick.SortKey
+		wantTimes bool
+	}{
+		{name: "nil", wantLimit: 5, wantSort: uberclick.SortByPrice, wantTimes: true},
+		{name: "unset", eo: &uberclick.EstimateOptions{}, wantLimit: 5, wantSort: uberclick.SortByPrice, wantTimes: true},
+		{
+			name:      "overridden",
+			eo:        &uberclick.EstimateOptions{Limit: 2, SortBy: uberclick.SortByDuration, PickupTimes: &off},
+			wantLimit: 2,
+			wantSort:  uberclick.SortByDuration,
+			wantTimes: false,
+		},
+	}
+	for _, tt := range tests {
+		got := tt.eo.Or(defaults)
+		if got.Limit != tt.wantLimit || got.SortBy != tt.wantSort || got.AddsPickupTimes() != tt.wantTimes {
+			t.Errorf("%s: Or = {%d %q %t}, want {%d %q %t}", tt.name,
+				got.Limit, got.SortBy, got.AddsPickupTimes(), tt.wantLimit, tt.wantSort, tt.wantTimes)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+// Package geocode resolves addresses to coordinates and back so that
+// trips can be given as typed addresses rather than points on a map.
+package geocode
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrNotFound is returned by Reverse when no place is near enough.
+var ErrNotFound = errors.New("geocode: no such place")
+
+type Place struct {
+	Address   string  `json:"address"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// Geocoder is implemented by geocoding backends. Implementations
+// must be safe for concurrent use.
+type Geocoder interface {
+	// Search returns at most limit places whose address
+	// matches query, the best match first.
+	Search(ctx context.Context, query string, limit int) ([]*Place, error)
+
+	// Reverse returns the place nearest to the coordinates
+	// or ErrNotFound if there is none near enough.
+	Reverse(ctx context.Context, lat, lng float64) (*Place, error)
+}
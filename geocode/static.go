@@ -0,0 +1,103 @@
+package geocode
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/odeke-em/uberclick"
+)
+
+// MaxReverseKilometres is how far from the coordinates given to
+// Reverse the place returned by a Static geocoder may be.
+const MaxReverseKilometres = 1
+
+// Static is a Geocoder over a fixed set of places, for tests and for
+// deployments that only serve trips between a few known addresses.
+type Static struct {
+	places []*Place
+}
+
+var _ Geocoder = (*Static)(nil)
+
+func NewStatic(places ...*Place) *Static {
+	return &Static{places: places}
+}
+
+// LoadStatic returns a Static geocoder over the
+// places in the JSON array in the file at path.
+func LoadStatic(path string) (*Static, error) {
+	blob, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var places []*Place
+	if err := json.Unmarshal(blob, &places); err != nil {
+		return nil, fmt.Errorf("geocode: parsing %q: %v", path, err)
+	}
+	return NewStatic(places...), nil
+}
+
+// Search matches the places whose address holds every word of
+// query regardless of case, preferring those whose address starts
+// with query and then the shortest addresses.
+func (s *Static) Search(ctx context.Context, query string, limit int) ([]*Place, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	query = strings.ToLower(strings.TrimSpace(query))
+	words := strings.Fields(query)
+	if len(words) == 0 {
+		return nil, nil
+	}
+
+	var matches []*Place
+	for _, p := range s.places {
+		address := strings.ToLower(p.Address)
+		matched := true
+		for _, word := range words {
+			if !strings.Contains(address, word) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			matches = append(matches, p)
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		a, b := strings.ToLower(matches[i].Address), strings.ToLower(matches[j].Address)
+		if ap, bp := strings.HasPrefix(a, query), strings.HasPrefix(b, query); ap != bp {
+			return ap
+		}
+		if len(a) != len(b) {
+			return len(a) < len(b)
+		}
+		return a < b
+	})
+	if limit > 0 && len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches, nil
+}
+
+func (s *Static) Reverse(ctx context.Context, lat, lng float64) (*Place, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var nearest *Place
+	nearestKm := math.Inf(1)
+	for _, p := range s.places {
+		if km := uberclick.KilometresBetween(lat, lng, p.Latitude, p.Longitude); km < nearestKm {
+			nearest, nearestKm = p, km
+		}
+	}
+	if nearest == nil || nearestKm > MaxReverseKilometres {
+		return nil, ErrNotFound
+	}
+	return nearest, nil
+}
@@ -0,0 +1,118 @@
+package geocode_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/odeke-em/uberclick/geocode"
+)
+
+var ferryBuilding = &geocode.Place{Address: "Ferry Building, San Francisco", Latitude: 37.7955, Longitude: -122.3937}
+
+var places = []*geocode.Place{
+	{Address: "1 Ferry Building Plaza, San Francisco", Latitude: 37.7956, Longitude: -122.3934},
+	ferryBuilding,
+	{Address: "685 Market St, San Francisco", Latitude: 37.7876, Longitude: -122.4034},
+	{Address: "1455 Market St, San Francisco", Latitude: 37.7749, Longitude: -122.4194},
+	{Address: "Market Hall, Oakland", Latitude: 37.8443, Longitude: -122.2510},
+}
+
+func addresses(places []*geocode.Place) []string {
+	var got []string
+	for _, p := range places {
+		got = append(got, p.Address)
+	}
+	return got
+}
+
+func TestSearch(t *testing.T) {
+	g := geocode.NewStatic(places...)
+	tests := []struct {
+		query string
+		limit int
+		want  []string
+	}{
+		// Addresses starting with the query come first,
+		// then the shortest, then alphabetically.
+		{"ferry", 0, []string{"Ferry Building, San Francisco", "1 Ferry Building Plaza, San Francisco"}},
+		{"MARKET st", 0, []string{"685 Market St, San Francisco", "1455 Market St, San Francisco"}},
+		{"market", 0, []string{"Market Hall, Oakland", "685 Market St, San Francisco", "1455 Market St, San Francisco"}},
+		{"market", 2, []string{"Market Hall, Oakland", "685 Market St, San Francisco"}},
+		// Every word must match, in any order.
+		{"francisco ferry plaza", 0, []string{"1 Ferry Building Plaza, San Francisco"}},
+		{"ferry oakland", 0, nil},
+		{"   ", 0, nil},
+	}
+	for _, tt := range tests {
+		got, err := g.Search(context.Background(), tt.query, tt.limit)
+		if err != nil {
+			t.Fatalf("Search(%q): %v", tt.query, err)
+		}
+		if strings.Join(addresses(got), "|") != strings.Join(tt.want, "|") {
+			t.Errorf("Search(%q, %d) = %q, want %q", tt.query, tt.limit, addresses(got), tt.want)
+		}
+	}
+}
+
+func TestReverse(t *testing.T) {
+	g := geocode.NewStatic(places...)
+	tests := []struct {
+		lat, lng float64
+		want     *geocode.Place
+		err      error
+	}{
+		{37.7955, -122.3937, ferryBuilding, nil},
+		// About 200m south of the Ferry Building.
+		{37.7937, -122.3937, ferryBuilding, nil},
+		// About 2km away from the nearest place.
+		{37.8135, -122.3937, nil, geocode.ErrNotFound},
+		{0, 0, nil, geocode.ErrNotFound},
+	}
+	for _, tt := range tests {
+		got, err := g.Reverse(context.Background(), tt.lat, tt.lng)
+		if got != tt.want || !errors.Is(err, tt.err) {
+			t.Errorf("Reverse(%v, %v) = (%v, %v), want (%v, %v)", tt.lat, tt.lng, got, err, tt.want, tt.err)
+		}
+	}
+	if _, err := geocode.NewStatic().Reverse(context.Background(), 37.7955, -122.3937); !errors.Is(err, geocode.ErrNotFound) {
+		t.Errorf("Reverse without places = %v, want %v", err, geocode.ErrNotFound)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := g.Reverse(ctx, 37.7955, -122.3937); !errors.Is(err, context.Canceled) {
+		t.Errorf("Reverse with a cancelled context = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestLoadStatic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "places.json")
+	blob := `[{"address": "Ferry Building, San Francisco", "latitude": 37.7955, "longitude": -122.3937}]`
+	if err := os.WriteFile(path, []byte(blob), 0600); err != nil {
+		t.Fatal(err)
+	}
+	g, err := geocode.LoadStatic(path)
+	if err != nil {
+		t.Fatalf("LoadStatic: %v", err)
+	}
+	got, err := g.Search(context.Background(), "ferry", 1)
+	if err != nil || len(got) != 1 || *got[0] != *ferryBuilding {
+		t.Errorf("Search of the loaded places = (%v, %v), want %v", addresses(got), err, ferryBuilding)
+	}
+
+	malformed := filepath.Join(dir, "malformed.json")
+	if err := os.WriteFile(malformed, []byte(`{"address": "not an array"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := geocode.LoadStatic(malformed); err == nil || !strings.Contains(err.Error(), malformed) {
+		t.Errorf("LoadStatic of malformed JSON = %v, want an error naming the file", err)
+	}
+	if _, err := geocode.LoadStatic(filepath.Join(dir, "missing.json")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("LoadStatic of a missing file = %v, want %v", err, os.ErrNotExist)
+	}
+}
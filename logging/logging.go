@@ -0,0 +1,143 @@
+// Package logging configures the structured, leveled logging of uberclick.
+// Records carry the ID of the request they were logged for and pass through
+// a redaction layer so that credentials never reach the logs in the clear.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// New returns a logger writing records of at least
+// level to w in format, either FormatText or FormatJSON.
+func New(w io.Writer, level slog.Leveler, format string) (*slog.Logger, error) {
+	opts := &slog.HandlerOptions{Level: level, ReplaceAttr: redactAttr}
+	var h slog.Handler
+	switch format {
+	case FormatText, "":
+		h = slog.NewTextHandler(w, opts)
+	case FormatJSON:
+		h = slog.NewJSONHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("logging: unknown format %q", format)
+	}
+	return slog.New(&contextHandler{Handler: h}), nil
+}
+
+// ParseLevel parses one of "debug", "info", "warn" or "error".
+func ParseLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("logging: unknown level %q", s)
+	}
+	return level, nil
+}
+
+type ctxKey int
+
+const (
+	requestIDKey ctxKey = iota
+	loggerKey
+)
+
+// NewContext returns a copy of ctx carrying l.
+func NewContext(ctx context.Context, l *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey, l)
+}
+
+// FromContext returns the logger carried by ctx
+// falling back to slog's default logger.
+func FromContext(ctx context.Context) *slog.Logger {
+	if l, ok := ctx.Value(loggerKey).(*slog.Logger); ok {
+		return l
+	}
+	return slog.Default()
+}
+
+// WithRequestID returns a copy of ctx whose
+// log records are attributed to request id.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// RequestID returns the request ID of ctx, if any.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// contextHandler adds the request ID and the trace
+// of the record's context, if any, to each record.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (ch *contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		r.AddAttrs(slog.String("trace_id", sc.TraceID().String()), slog.String("span_id", sc.SpanID().String()))
+	}
+	return ch.Handler.Handle(ctx, r)
+}
+
+func (ch *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &contextHandler{Handler: ch.Handler.WithAttrs(attrs)}
+}
+
+func (ch *contextHandler) WithGroup(name string) slog.Handler {
+	return &contextHandler{Handler: ch.Handler.WithGroup(name)}
+}
+
+// Secret is a credential such as a token, nonce, cookie
+// or API key. It is only ever logged in redacted form.
+type Secret string
+
+func (s Secret) LogValue() slog.Value { return slog.StringValue(redact(string(s))) }
+
+// String redacts s too so that it is safe in formatted messages.
+func (s Secret) String() string { return redact(string(s)) }
+
+// redact keeps a short prefix of s which is enough to correlate
+// records of the same secret but not to reconstruct it.
+func redact(s string) string {
+	if len(s) < 12 {
+		return "[REDACTED]"
+	}
+	return s[:4] + "…[REDACTED]"
+}
+
+// sensitiveKeys are attribute keys whose values are
+// redacted even when not logged as a Secret.
+var sensitiveKeys = map[string]bool{
+	"access_token":  true,
+	"api_key":       true,
+	"authorization": true,
+	"client_secret": true,
+	"code":          true,
+	"cookie":        true,
+	"nonce":         true,
+	"password":      true,
+	"refresh_token": true,
+	"set-cookie":    true,
+	"state":         true,
+	"token":         true,
+}
+
+func redactAttr(_ []string, a slog.Attr) slog.Attr {
+	if sensitiveKeys[strings.ToLower(a.Key)] && a.Value.Kind() == slog.KindString {
+		a.Value = slog.StringValue(redact(a.Value.String()))
+	}
+	return a
+}
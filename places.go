@@ -0,0 +1,51 @@
+package uberclick
+
+// The IDs of the places that riders can save with Uber
+// and give instead of coordinates as trip ends.
+const (
+	PlaceHome = "home"
+	PlaceWork = "work"
+)
+
+// IsSavedPlace reports whether id is the ID of a saved place.
+func IsSavedPlace(id string) bool {
+	return id == PlaceHome || id == PlaceWork
+}
+
+var (
+	ErrInvalidPlace = &Err{
+		Code:    CodeInvalidPlace,
+		Reason:  "invalid place",
+		Details: `expecting the place ID "home" or "work"`,
+	}
+	ErrInvalidAddress = &Err{
+		Code:    CodeInvalidAddress,
+		Reason:  "invalid address",
+		Details: "expecting a non-blank address",
+	}
+	ErrMethodNotAllowed = &Err{
+		Code:    CodeMethodNotAllowed,
+		Reason:  "method not allowed",
+		Details: "the route does not support this HTTP method",
+	}
+	ErrInvalidQuery = &Err{
+		Code:    CodeInvalidQuery,
+		Reason:  "invalid query",
+		Details: "expecting a non-blank address to search for",
+	}
+	ErrPlaceNotFound = &Err{
+		Code:    CodePlaceNotFound,
+		Reason:  "place not found",
+		Details: "no known place is near the coordinates",
+	}
+	ErrUnlocatablePlace = &Err{
+		Code:    CodeUnlocatablePlace,
+		Reason:  "unlocatable place",
+		Details: "the address of the saved place could not be geocoded",
+	}
+	ErrGeocodingUnavailable = &Err{
+		Code:    CodeGeocodingUnavailable,
+		Reason:  "geocoding unavailable",
+		Details: "the server was not configured with a geocoder",
+	}
+)
@@ -0,0 +1,173 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	"github.com/odeke-em/uberclick"
+)
+
+type loginData struct {
+	APIKey string `json:"api_key"`
+	Origin string `json:"origin"`
+}
+
+type usage struct {
+	TimeAt    int64  `json:"t,omitempty"`
+	OriginURL string `json:"o,omitempty"`
+}
+
+const (
+	apiKeyUsageTable = "api-key-usage"
+)
+
+func (s *Server) registerUsageOfAPIKey(ctx context.Context, key string, unixTime int64, req *http.Request) error {
+	originURL := fmt.Sprintf("%s://%s", scheme(req), req.Host)
+	if query := req.URL.Query(); len(query) > 0 {
+		originURL += "?" + query.Encode()
+	}
+	blob, _ := json.Marshal(&usage{TimeAt: unixTime, OriginURL: originURL})
+	return s.store.LPush(ctx, apiKeyUsageTable, blob)
+}
+
+func (s *Server) withAPIAuthdDomains(rw http.ResponseWriter, req *http.Request, next func()) {
+	if s.authorizeDomain(rw, req) {
+		next()
+	}
+}
+
+// authorizeDomain checks that the API key in the body of
+// req is registered for its origin, replying to rw if not.
+func (s *Server) authorizeDomain(rw http.ResponseWriter, req *http.Request) bool {
+	req, span := s.startSpan(req, "withAPIAuthdDomains")
+	defer span.End()
+
+	ldata := new(loginData)
+	if !s.readJSON(rw, req, ldata) {
+		return false
+	}
+
+	return s.authorizeKey(rw, req, ldata.APIKey, ldata.Origin)
+}
+
+// authorizeQueryDomain is authorizeDomain for routes fetched without a
+// body, which take the API key from the api_key query parameter or the
+// X-API-Key header and the origin from the query or the Origin header.
+func (s *Server) authorizeQueryDomain(rw http.ResponseWriter, req *http.Request) bool {
+	req, span := s.startSpan(req, "withAPIAuthdDomains")
+	defer span.End()
+
+	query := req.URL.Query()
+	key := query.Get("api_key")
+	if key == "" {
+		key = req.Header.Get("X-API-Key")
+	}
+	origin := query.Get("origin")
+	if origin == "" {
+		origin = req.Header.Get("Origin")
+	}
+	if key == "" {
+		replyError(rw, req, errInvalidAPIKey, nil)
+		return false
+	}
+	return s.authorizeKey(rw, req, key, origin)
+}
+
+// authorizeKey records the usage of key and
+// checks that origin is registered for it.
+func (s *Server) authorizeKey(rw http.ResponseWriter, req *http.Request, key, origin string) bool {
+	// Usage is recorded in the background so it must
+	// not be cancelled when the request completes.
+	go s.registerUsageOfAPIKey(context.WithoutCancel(req.Context()), key, time.Now().Unix(), req)
+
+	return s.allowedOrigin(rw, req, key, origin)
+}
+
+// allowedOrigin checks that the domain of origin is registered
+// for the API key, replying to rw if it is not.
+func (s *Server) allowedOrigin(rw http.ResponseWriter, req *http.Request, key, origin string) bool {
+	originURL, err := url.Parse(origin)
+	if err != nil {
+		replyError(rw, req, errInvalidOrigin, err)
+		return false
+	}
+
+	reg := &uberclick.RedisAPIKeyRegistration{APIKey: key}
+	allowedDomain, err := reg.AllowedDomain(req.Context(), s.store, originURL.Host)
+	if err != nil {
+		replyError(rw, req, storeError(err), err)
+		return false
+	}
+	if allowedDomain {
+		return true
+	}
+	// Only once the origin is refused is it worth
+	// telling apart keys that were never registered.
+	registered, err := reg.Registered(req.Context(), s.store)
+	switch {
+	case err != nil:
+		replyError(rw, req, storeError(err), err)
+	case !registered:
+		replyError(rw, req, errUnknownAPIKey, nil)
+	default:
+		replyError(rw, req, errDomainNotAllowed, nil)
+	}
+	return false
+}
+
+func (s *Server) withAPIKeyAuthdAndWithAuthToken(rw http.ResponseWriter, req *http.Request, fn func(*oauth2.Token)) {
+	s.withAPIAuthdDomains(rw, req, func() {
+		s.withAuthToken(rw, req, fn)
+	})
+}
+
+func (s *Server) withAuthToken(rw http.ResponseWriter, req *http.Request, fn func(*oauth2.Token)) {
+	if token := s.authToken(rw, req); token != nil {
+		fn(token)
+	}
+}
+
+// authToken returns the OAuth2.0 token that the cookie of req refers
+// to. If there is none, it replies to rw with how to obtain one.
+func (s *Server) authToken(rw http.ResponseWriter, req *http.Request) *oauth2.Token {
+	req, span := s.startSpan(req, "withAuthToken")
+	defer span.End()
+
+	uberNonceCookie, err := req.Cookie(cookieName)
+	if err != nil {
+		s.unauthenticated(rw, req, nil)
+		return nil
+	}
+
+	nonce := uberNonceCookie.Value
+	token, err := s.memoizedOAuth2Token(req.Context(), nonce)
+	if errors.Is(err, errCacheMiss) {
+		s.unauthenticated(rw, req, err)
+		return nil
+	}
+	if err != nil {
+		replyError(rw, req, storeError(err), err)
+		return nil
+	}
+	if !token.Valid() && token.RefreshToken == "" {
+		replyError(rw, req, errTokenExpired, nil)
+		return nil
+	}
+
+	return token
+}
+
+// unauthenticated replies that req has no session, pointing
+// clients in the meta of the error at the grant to start one.
+func (s *Server) unauthenticated(rw http.ResponseWriter, req *http.Request, cause error) {
+	loginURL := fmt.Sprintf("%s://%s/grant", scheme(req), req.Host)
+	apiErr := &apiError{errUnauthenticated.status, errUnauthenticated.err.WithMeta(&authInfo{URL: loginURL})}
+	replyError(rw, req, apiErr, cause)
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"sync"
+
+	"github.com/odeke-em/uberclick"
+)
+
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+func withBuffer(fn func(*bytes.Buffer)) {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	defer func() {
+		buf.Reset()
+		bufferPool.Put(buf)
+	}()
+
+	fn(buf)
+}
+
+func jsonEncodeUnescapedHTML(v interface{}) ([]byte, error) {
+	var blob []byte
+	var err error
+	withBuffer(func(buf *bytes.Buffer) {
+		enc := json.NewEncoder(buf)
+		enc.SetEscapeHTML(false)
+		if err = enc.Encode(v); err != nil {
+			return
+		}
+		// Copy out since buf is recycled once we return.
+		blob = append([]byte(nil), buf.Bytes()...)
+	})
+
+	return blob, err
+}
+
+// readJSON decodes the JSON body of req into recv. On failure
+// it replies to rw and reports false.
+func (s *Server) readJSON(rw http.ResponseWriter, req *http.Request, recv interface{}) bool {
+	return s.readBody(rw, req, func(r io.Reader) error {
+		return uberclick.DecodeJSON(r, recv)
+	})
+}
+
+// readBody hands decode the body of req, size capped,
+// once it is known to be JSON. On failure it replies
+// to rw and reports false.
+func (s *Server) readBody(rw http.ResponseWriter, req *http.Request, decode func(io.Reader) error) bool {
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if mediaType != "application/json" {
+		replyError(rw, req, errUnsupportedMedia, nil)
+		return false
+	}
+
+	body := http.MaxBytesReader(rw, req.Body, s.maxBodyBytes)
+	defer body.Close()
+
+	err := decode(body)
+	var mbe *http.MaxBytesError
+	var we *uberclick.WrappedError
+	switch {
+	case err == nil:
+		return true
+	case errors.As(err, &mbe):
+		replyError(rw, req, errBodyTooLarge, err)
+	case errors.As(err, &we):
+		replyErrors(rw, http.StatusBadRequest, we.Errors...)
+	default:
+		replyError(rw, req, errInvalidBody, err)
+	}
+	return false
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+
+	"golang.org/x/oauth2"
+
+	"github.com/odeke-em/uberclick"
+	"github.com/odeke-em/uberclick/logging"
+	"github.com/odeke-em/uberclick/store"
+)
+
+// apiError is a failure as presented to clients:
+// a status and details that are safe to reveal.
+type apiError struct {
+	status int
+	err    *uberclick.Err
+}
+
+var (
+	errInvalidBody          = &apiError{http.StatusBadRequest, uberclick.ErrInvalidBody}
+	errBodyTooLarge         = &apiError{http.StatusRequestEntityTooLarge, uberclick.ErrBodyTooLarge}
+	errUnsupportedMedia     = &apiError{http.StatusUnsupportedMediaType, uberclick.ErrUnsupportedMedia}
+	errInvalidAPIKey        = &apiError{http.StatusBadRequest, uberclick.ErrInvalidAPIKey}
+	errUnknownAPIKey        = &apiError{http.StatusForbidden, uberclick.ErrUnknownAPIKey}
+	errInvalidOrigin        = &apiError{http.StatusBadRequest, uberclick.ErrInvalidOrigin}
+	errDomainNotAllowed     = &apiError{http.StatusForbidden, uberclick.ErrDomainNotAllowed}
+	errInvalidState         = &apiError{http.StatusBadRequest, uberclick.ErrInvalidState}
+	errNonceReplayed        = &apiError{http.StatusConflict, uberclick.ErrNonceReplayed}
+	errExchangeFailed       = &apiError{http.StatusBadRequest, uberclick.ErrExchangeFailed}
+	errUnknownNonce         = &apiError{http.StatusNotFound, uberclick.ErrUnknownNonce}
+	errUnauthenticated      = &apiError{http.StatusUnauthorized, uberclick.ErrUnauthenticated}
+	errTokenExpired         = &apiError{http.StatusUnauthorized, uberclick.ErrTokenExpired}
+	errMethodNotAllowed     = &apiError{http.StatusMethodNotAllowed, uberclick.ErrMethodNotAllowed}
+	errPlaceNotFound        = &apiError{http.StatusNotFound, uberclick.ErrPlaceNotFound}
+	errUnlocatablePlace     = &apiError{http.StatusUnprocessableEntity, uberclick.ErrUnlocatablePlace}
+	errGeocodingUnavailable = &apiError{http.StatusNotImplemented, uberclick.ErrGeocodingUnavailable}
+	errStoreUnavailable     = &apiError{http.StatusServiceUnavailable, uberclick.ErrStoreUnavailable}
+	errUpstream             = &apiError{http.StatusBadGateway, uberclick.ErrUpstream}
+	errUpstreamUnavailable  = &apiError{http.StatusServiceUnavailable, uberclick.ErrUpstreamUnavailable}
+	errUpstreamTimeout      = &apiError{http.StatusGatewayTimeout, uberclick.ErrUpstreamTimeout}
+	errInternal             = &apiError{http.StatusInternalServerError, uberclick.ErrInternal}
+)
+
+// replyError writes apiErr as WrappedError JSON. cause, which
+// may contain internal details, is logged but never revealed.
+func replyError(rw http.ResponseWriter, req *http.Request, apiErr *apiError, cause error) {
+	if cause != nil {
+		level := slog.LevelInfo
+		if apiErr.status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		logging.FromContext(req.Context()).Log(req.Context(), level, "request failed",
+			"path", req.URL.Path, "error_code", apiErr.err.Code, "err", cause)
+	}
+	replyErrors(rw, apiErr.status, apiErr.err)
+}
+
+func replyErrors(rw http.ResponseWriter, status int, errs ...*uberclick.Err) {
+	blob, _ := jsonEncodeUnescapedHTML(&uberclick.WrappedError{Errors: errs})
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	rw.Write(blob)
+}
+
+// storeError classifies the failure of a store operation.
+func storeError(err error) *apiError {
+	switch {
+	case errors.Is(err, store.ErrCircuitOpen), errors.Is(err, store.ErrClosed),
+		store.IsConnError(err), errors.Is(err, context.DeadlineExceeded):
+		return errStoreUnavailable
+	default:
+		return errInternal
+	}
+}
+
+// upstreamError classifies the failure of a call to the Uber API.
+func upstreamError(err error) *apiError {
+	var ne net.Error
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return errUpstreamTimeout
+	case errors.As(err, &ne):
+		return errUpstreamUnavailable
+	default:
+		return errUpstream
+	}
+}
+
+// exchangeError classifies the failure of an OAuth2.0 token exchange
+// telling apart rejected codes from an unreachable token endpoint.
+func exchangeError(err error) *apiError {
+	var re *oauth2.RetrieveError
+	if errors.As(err, &re) && re.Response != nil && re.Response.StatusCode < http.StatusInternalServerError {
+		return errExchangeFailed
+	}
+	return upstreamError(err)
+}
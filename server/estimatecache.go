@@ -0,0 +1,174 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+
+	"github.com/odeke-em/uberclick"
+)
+
+// DefaultEstimateCacheTTL is how long estimates are
+// reused for identical requests of the same user.
+const DefaultEstimateCacheTTL = 30 * time.Second
+
+// maxCachedEstimates bounds the memory held by the cache
+// should many distinct trips be estimated within a TTL.
+const maxCachedEstimates = 10000
+
+// coordinatePrecision is the number of decimal places that coordinates
+// are rounded to in cache keys, about 11m at the equator.
+const coordinatePrecision = 4
+
+type cachedEstimates struct {
+	value interface{}
+	at    time.Time
+}
+
+// estimateCache holds recent estimates and deduplicates
+// concurrent lookups of the same key so that only one of
+// them reaches the Uber API.
+type estimateCache struct {
+	ttl    time.Duration
+	flight singleflight.Group
+
+	mu      sync.Mutex
+	entries map[string]*cachedEstimates
+}
+
+func newEstimateCache(ttl time.Duration) *estimateCache {
+	return &estimateCache{ttl: ttl, entries: make(map[string]*cachedEstimates)}
+}
+
+// get returns the entry cached under key, reporting that it was
+// cached, otherwise one holding the result of fetch which is cached
+// if it succeeds and fetch reports it as keepable. Callers that ask
+// for the same key while fetch is in flight share its result.
+func (ec *estimateCache) get(key string, fetch func() (value interface{}, keep bool, err error)) (*cachedEstimates, bool, error) {
+	if ce := ec.lookup(key); ce != nil {
+		return ce, true, nil
+	}
+
+	v, err, _ := ec.flight.Do(key, func() (interface{}, error) {
+		value, keep, err := fetch()
+		if err != nil {
+			return nil, err
+		}
+		ce := &cachedEstimates{value: value, at: time.Now()}
+		if keep && ec.ttl > 0 {
+			ec.store(key, ce)
+		}
+		return ce, nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return v.(*cachedEstimates), false, nil
+}
+
+func (ec *estimateCache) lookup(key string) *cachedEstimates {
+	ec.mu.Lock()
+	defer ec.mu.Unlock()
+
+	ce := ec.entries[key]
+	if ce == nil || ec.ttl <= 0 {
+		return nil
+	}
+	if time.Since(ce.at) >= ec.ttl {
+		delete(ec.entries, key)
+		return nil
+	}
+	return ce
+}
+
+// forget drops every entry whose key matches.
+func (ec *estimateCache) forget(match func(key string) bool) {
+	ec.mu.Lock()
+	defer ec.mu.Unlock()
+
+	for key := range ec.entries {
+		if match(key) {
+			delete(ec.entries, key)
+		}
+	}
+}
+
+func (ec *estimateCache) store(key string, ce *cachedEstimates) {
+	ec.mu.Lock()
+	defer ec.mu.Unlock()
+
+	if len(ec.entries) >= maxCachedEstimates {
+		for k, old := range ec.entries {
+			if time.Since(old.at) >= ec.ttl {
+				delete(ec.entries, k)
+			}
+		}
+	}
+	if len(ec.entries) < maxCachedEstimates {
+		ec.entries[key] = ce
+	}
+}
+
+// cachedLookup is the get of cache for lookups made on behalf of req.
+// The lookup is shared by identical requests so it is not abandoned
+// if req goes away, only if the Server is closed.
+func (s *Server) cachedLookup(cache *estimateCache, req *http.Request, key string, lookup func(*http.Request) (interface{}, bool, error)) (*cachedEstimates, bool, error) {
+	return cache.get(key, func() (interface{}, bool, error) {
+		ctx, cancel := context.WithCancel(context.WithoutCancel(req.Context()))
+		defer cancel()
+		stop := context.AfterFunc(s.ctx, cancel)
+		defer stop()
+
+		return lookup(req.WithContext(ctx))
+	})
+}
+
+// writeCached replies with the value of ce. Age lets the
+// widget tell how fresh it is and X-Cache whether it was
+// looked up for this request.
+func writeCached(rw http.ResponseWriter, req *http.Request, ce *cachedEstimates, cached bool) {
+	blob, err := jsonEncodeUnescapedHTML(ce.value)
+	if err != nil {
+		replyError(rw, req, errInternal, err)
+		return
+	}
+	rw.Header().Set("Age", strconv.Itoa(int(time.Since(ce.at)/time.Second)))
+	if cached {
+		rw.Header().Set("X-Cache", "HIT")
+	} else {
+		rw.Header().Set("X-Cache", "MISS")
+	}
+	rw.Write(blob)
+}
+
+func roundCoordinate(f float64) float64 {
+	scale := math.Pow10(coordinatePrecision)
+	return math.Round(f*scale) / scale
+}
+
+// estimateCacheKey identifies the trip that user asked to be
+// estimated, treating nearby coordinates as the same point.
+func estimateCacheKey(user string, t *uberclick.Trip) string {
+	r := roundCoordinate
+	return fmt.Sprintf("%s|%.*f,%.*f,%s|%.*f,%.*f,%s|%d", user,
+		coordinatePrecision, r(t.StartLatitude), coordinatePrecision, r(t.StartLongitude), t.StartPlace,
+		coordinatePrecision, r(t.EndLatitude), coordinatePrecision, r(t.EndLongitude), t.EndPlace,
+		t.SeatCount)
+}
+
+// estimateCacheKeyHasPlace reports whether key, made by
+// estimateCacheKey, is of a trip of user to or from place.
+func estimateCacheKeyHasPlace(key, user, place string) bool {
+	parts := strings.SplitN(key, "|", 4)
+	if len(parts) < 3 || parts[0] != user {
+		return false
+	}
+	return strings.HasSuffix(parts[1], ","+place) || strings.HasSuffix(parts[2], ","+place)
+}
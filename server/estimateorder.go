@@ -0,0 +1,148 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/odeke-em/uberclick"
+)
+
+// maxEstimateCandidates bounds how many estimates are
+// paged through before they are filtered and ranked.
+const maxEstimateCandidates = 2 * uberclick.MaxEstimateLimit
+
+// fareLookupMargin is how many of the candidates ranked below the
+// limit also have their upfront fares looked up, in case the trip
+// durations or pickup estimates of the fares order them differently
+// than their price estimates did. Prices are never reordered by fares.
+const fareLookupMargin = 2
+
+// The facts that estimates are sorted by are read from the JSON of the
+// Uber API, the way uberclick.TripOf does, so that missing values can
+// be told apart from zeros.
+type priceFacts struct {
+	LowEstimate *float64 `json:"low_estimate"`
+	Duration    *float64 `json:"duration"`
+}
+
+type fareFacts struct {
+	Trip *struct {
+		DurationEstimate *float64 `json:"duration_estimate"`
+	} `json:"trip"`
+	// PickupEstimate is in minutes.
+	PickupEstimate *float64 `json:"pickup_estimate"`
+}
+
+type timeFacts struct {
+	// Estimate is in seconds.
+	Estimate *float64 `json:"estimate"`
+}
+
+func transcode(v, recv interface{}) error {
+	blob, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(blob, recv)
+}
+
+// sortValue returns what pair is ordered by under key, lowest first,
+// and reports false if the Uber API did not provide it.
+func (pair *estimateAndUpfrontFarePair) sortValue(key uberclick.SortKey) (float64, bool) {
+	var pf priceFacts
+	var ff fareFacts
+	transcode(pair.Estimate, &pf)
+	if pair.UpfrontFare != nil {
+		transcode(pair.UpfrontFare, &ff)
+	}
+
+	var v *float64
+	switch key {
+	case uberclick.SortByPrice:
+		// Only some estimates get an upfront fare, and it is
+		// not comparable with the range of a price estimate,
+		// so prices are always ranked by their low estimate.
+		v = pf.LowEstimate
+	case uberclick.SortByPickupETA:
+		var tf timeFacts
+		if pair.PickupTime != nil {
+			transcode(pair.PickupTime, &tf)
+		}
+		if tf.Estimate != nil {
+			return *tf.Estimate, true
+		}
+		if ff.PickupEstimate != nil {
+			return *ff.PickupEstimate * 60, true
+		}
+	case uberclick.SortByDuration:
+		v = pf.Duration
+		if ff.Trip != nil && ff.Trip.DurationEstimate != nil {
+			v = ff.Trip.DurationEstimate
+		}
+	case uberclick.SortByCapacity:
+		if pair.product != nil && pair.product.Capacity > 0 {
+			// The roomiest products come first.
+			return -float64(pair.product.Capacity), true
+		}
+	}
+	if v == nil {
+		return 0, false
+	}
+	return *v, true
+}
+
+// sortEstimates orders pairs by key. Pairs lacking the value come last
+// and ties are broken by display name then product ID, so that the
+// same estimates are always returned in the same order.
+func sortEstimates(pairs []*estimateAndUpfrontFarePair, key uberclick.SortKey) {
+	type ranked struct {
+		pair  *estimateAndUpfrontFarePair
+		value float64
+		ok    bool
+	}
+	rs := make([]*ranked, len(pairs))
+	for i, pair := range pairs {
+		value, ok := pair.sortValue(key)
+		rs[i] = &ranked{pair: pair, value: value, ok: ok}
+	}
+	sort.SliceStable(rs, func(i, j int) bool {
+		a, b := rs[i], rs[j]
+		if a.ok != b.ok {
+			return a.ok
+		}
+		if a.ok && a.value != b.value {
+			return a.value < b.value
+		}
+		if a.pair.Estimate.DisplayName != b.pair.Estimate.DisplayName {
+			return a.pair.Estimate.DisplayName < b.pair.Estimate.DisplayName
+		}
+		return a.pair.Estimate.ProductID < b.pair.Estimate.ProductID
+	})
+	for i, r := range rs {
+		pairs[i] = r.pair
+	}
+}
+
+// needsProducts reports whether estimates shaped by
+// opts require the details of the products offered.
+func needsProducts(opts *uberclick.EstimateOptions) bool {
+	return len(opts.ProductTypes) > 0 || opts.SortBy == uberclick.SortByCapacity
+}
+
+// needsTimes reports whether estimates shaped by opts
+// require the pickup time estimates of the products.
+func needsTimes(opts *uberclick.EstimateOptions) bool {
+	return opts.AddsPickupTimes() || opts.SortBy == uberclick.SortByPickupETA
+}
+
+// estimateOptionsKey identifies opts within estimate cache keys.
+func estimateOptionsKey(opts *uberclick.EstimateOptions) string {
+	types := make([]string, 0, len(opts.ProductTypes))
+	for _, pt := range opts.ProductTypes {
+		types = append(types, strings.ToLower(strings.TrimSpace(pt)))
+	}
+	sort.Strings(types)
+	return fmt.Sprintf("%d|%s|%s|%t", opts.Limit, opts.SortBy, strings.Join(types, ","), opts.AddsPickupTimes())
+}
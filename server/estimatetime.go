@@ -0,0 +1,151 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"golang.org/x/oauth2"
+
+	"github.com/orijtech/uber/v1"
+
+	"github.com/odeke-em/uberclick"
+	"github.com/odeke-em/uberclick/geocode"
+)
+
+// timesReply holds the pickup time estimates at a place along
+// with what, if anything, kept some of them from being listed.
+type timesReply struct {
+	Status string               `json:"status"`
+	Times  []*uber.TimeEstimate `json:"times"`
+	Errors []*uberclick.Err     `json:"errors,omitempty"`
+}
+
+func (s *Server) estimateTime(rw http.ResponseWriter, req *http.Request) {
+	s.withAuthToken(rw, req, func(token *oauth2.Token) {
+		esReq := new(uber.EstimateRequest)
+		if !s.readJSON(rw, req, esReq) {
+			return
+		}
+		trip, ok := s.validTrip(rw, req, esReq, (*uberclick.Trip).ValidateRide)
+		if !ok {
+			return
+		}
+
+		// withAuthToken has ensured that the cookie is present.
+		user, _ := req.Cookie(cookieName)
+		// Pickup times depend only on where the trip starts
+		// and on the seats and product that they are asked for.
+		key := estimateCacheKey(user.Value, &uberclick.Trip{
+			StartLatitude:  trip.StartLatitude,
+			StartLongitude: trip.StartLongitude,
+			StartPlace:     trip.StartPlace,
+			SeatCount:      trip.SeatCount,
+		}) + "|time|" + esReq.ProductID
+		ce, cached, err := s.cachedLookup(s.estimates, req, key, func(req *http.Request) (interface{}, bool, error) {
+			uberC, err := s.uberClient(req, token)
+			if err != nil {
+				return nil, false, err
+			}
+			times, err := s.lookupTimes(req, uberC, esReq)
+			if len(times) == 0 && err != nil {
+				return nil, false, err
+			}
+			reply := &timesReply{Status: estimatesComplete, Times: times}
+			if err != nil {
+				reply.Status = estimatesPartial
+				reply.Errors = append(reply.Errors, lookupError(err).err.WithMeta(&estimateErrorMeta{
+					Operation: upstreamEstimateTime,
+				}))
+			}
+			return reply, reply.Status == estimatesComplete, nil
+		})
+		if err != nil {
+			replyError(rw, req, lookupError(err), err)
+			return
+		}
+		writeCached(rw, req, ce, cached)
+	})
+}
+
+// lookupTimes pages through the pickup time estimates at the start
+// of esReq. Should paging fail midway, the estimates listed so far
+// are returned along with the error.
+func (s *Server) lookupTimes(req *http.Request, uberC *uber.Client, esReq *uber.EstimateRequest) ([]*uber.TimeEstimate, error) {
+	start, err := s.locateStart(req, uberC, esReq)
+	if err != nil {
+		return nil, err
+	}
+
+	end := s.startUpstream(req.Context(), upstreamEstimateTime)
+	pagesChan, cancelPaging, err := uberC.EstimateTime(&uber.EstimateRequest{
+		StartLatitude:  start.Latitude,
+		StartLongitude: start.Longitude,
+		SeatCount:      esReq.SeatCount,
+		ProductID:      esReq.ProductID,
+	})
+	if err != nil {
+		end(err)
+		return nil, err
+	}
+
+	var times []*uber.TimeEstimate
+	var pagingErr error
+	for page := range pagesChan {
+		if page.Err == nil {
+			times = append(times, page.Estimates...)
+		} else if pagingErr == nil {
+			pagingErr = page.Err
+		}
+		if req.Context().Err() != nil {
+			cancelPaging()
+		}
+	}
+	end(pagingErr)
+	return times, pagingErr
+}
+
+// errNoGeocoder is returned when a saved place has
+// to be located but no Geocoder was configured.
+var errNoGeocoder = errors.New("server: no geocoder to locate saved places")
+
+// locateStart returns where esReq starts. A saved place is looked up
+// and, as the Uber API only gives its address, geocoded.
+func (s *Server) locateStart(req *http.Request, uberC *uber.Client, esReq *uber.EstimateRequest) (*uber.Place, error) {
+	if esReq.StartPlace == "" {
+		return &uber.Place{Latitude: esReq.StartLatitude, Longitude: esReq.StartLongitude}, nil
+	}
+	if s.geocoder == nil {
+		return nil, errNoGeocoder
+	}
+	end := s.startUpstream(req.Context(), upstreamPlace)
+	place, err := uberC.Place(esReq.StartPlace)
+	end(err)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, span := s.tracer.Start(req.Context(), "geocode.search")
+	matches, err := s.geocoder.Search(ctx, place.Address, 1)
+	if err == nil && len(matches) == 0 {
+		span.End()
+		return nil, geocode.ErrNotFound
+	}
+	endSpan(span, err)
+	if err != nil {
+		return nil, err
+	}
+	return &uber.Place{Address: place.Address, Latitude: matches[0].Latitude, Longitude: matches[0].Longitude}, nil
+}
+
+// lookupError classifies the failure of a lookup that, besides
+// calling the Uber API, may have had to locate a saved place.
+func lookupError(err error) *apiError {
+	switch {
+	case errors.Is(err, errNoGeocoder):
+		return errGeocodingUnavailable
+	case errors.Is(err, geocode.ErrNotFound):
+		return errUnlocatablePlace
+	default:
+		return upstreamError(err)
+	}
+}
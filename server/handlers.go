@@ -0,0 +1,468 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"golang.org/x/oauth2"
+
+	"github.com/odeke-em/go-uuid"
+	"github.com/odeke-em/semalim"
+	"github.com/orijtech/uber/v1"
+
+	"github.com/odeke-em/uberclick"
+	"github.com/odeke-em/uberclick/logging"
+	"github.com/odeke-em/uberclick/store"
+)
+
+func (s *Server) initAuth(rw http.ResponseWriter, req *http.Request) {
+	s.withAPIAuthdDomains(rw, req, func() {
+		fmt.Fprintf(rw, "Authenticated")
+	})
+}
+
+func (s *Server) registerDomains(rw http.ResponseWriter, req *http.Request) {
+	var domains []string
+	if !s.readJSON(rw, req, &domains) {
+		return
+	}
+
+	generatedAPIKey := uuid.NewRandom().String()
+	reg := &uberclick.RedisAPIKeyRegistration{APIKey: generatedAPIKey}
+	if err := reg.RegisterDomains(req.Context(), s.store, domains...); err != nil {
+		replyError(rw, req, storeError(err), err)
+		return
+	}
+
+	blob, _ := json.Marshal(reg)
+	rw.Write(blob)
+}
+
+type estimateDefaultsRequest struct {
+	APIKey string `json:"api_key"`
+	Origin string `json:"origin"`
+	uberclick.EstimateOptions
+}
+
+// setEstimateDefaults saves the estimate options of an API key,
+// which may only be changed from one of its registered domains.
+func (s *Server) setEstimateDefaults(rw http.ResponseWriter, req *http.Request) {
+	edReq := new(estimateDefaultsRequest)
+	if !s.readJSON(rw, req, edReq) {
+		return
+	}
+	if strings.TrimSpace(edReq.APIKey) == "" {
+		replyError(rw, req, errInvalidAPIKey, nil)
+		return
+	}
+	if we := edReq.EstimateOptions.Validate(); we != nil {
+		replyErrors(rw, http.StatusBadRequest, we.Errors...)
+		return
+	}
+	if !s.allowedOrigin(rw, req, edReq.APIKey, edReq.Origin) {
+		return
+	}
+
+	reg := &uberclick.RedisAPIKeyRegistration{APIKey: edReq.APIKey}
+	if err := reg.SetEstimateDefaults(req.Context(), s.store, &edReq.EstimateOptions); err != nil {
+		replyError(rw, req, storeError(err), err)
+		return
+	}
+	blob, _ := jsonEncodeUnescapedHTML(&edReq.EstimateOptions)
+	rw.Write(blob)
+}
+
+func (s *Server) order(rw http.ResponseWriter, req *http.Request) {
+	s.withAuthToken(rw, req, func(token *oauth2.Token) {
+		rreq := new(uber.RideRequest)
+		if !s.readJSON(rw, req, rreq) {
+			return
+		}
+		if _, ok := s.validTrip(rw, req, rreq, (*uberclick.Trip).ValidateRide); !ok {
+			return
+		}
+		fmt.Fprintf(rw, "Ordering it, complete me and finally!!!")
+	})
+}
+
+// validTrip checks the trip described by v, such as a ride
+// or an estimate request. On failure it replies to rw with
+// every problem found and reports false.
+func (s *Server) validTrip(rw http.ResponseWriter, req *http.Request, v interface{}, validate func(*uberclick.Trip) *uberclick.WrappedError) (*uberclick.Trip, bool) {
+	trip, err := uberclick.TripOf(v)
+	if err != nil {
+		replyError(rw, req, errInternal, err)
+		return nil, false
+	}
+	if we := validate(trip); we != nil {
+		replyErrors(rw, http.StatusBadRequest, we.Errors...)
+		return nil, false
+	}
+	return trip, true
+}
+
+func (s *Server) profile(rw http.ResponseWriter, req *http.Request) {
+	s.withAPIKeyAuthdAndWithAuthToken(rw, req, func(token *oauth2.Token) {
+		uberC, err := s.uberClient(req, token)
+		if err != nil {
+			replyError(rw, req, errInternal, err)
+			return
+		}
+		end := s.startUpstream(req.Context(), upstreamProfile)
+		myProfile, err := uberC.RetrieveMyProfile()
+		end(err)
+		if err != nil {
+			replyError(rw, req, upstreamError(err), err)
+			return
+		}
+		blob, _ := jsonEncodeUnescapedHTML(myProfile)
+		rw.Write(blob)
+	})
+}
+
+func (s *Server) deauth(rw http.ResponseWriter, req *http.Request) {
+	var subm *uberclick.Submission
+	if !s.readBody(rw, req, func(r io.Reader) (err error) {
+		subm, err = uberclick.FparseSubmission(r)
+		return err
+	}) {
+		return
+	}
+	popdConfig, err := s.popOAuth2Config(req.Context(), subm.Nonce)
+	switch {
+	case err == nil:
+	case errors.Is(err, store.ErrNotFound):
+		replyError(rw, req, errUnknownNonce, err)
+		return
+	default:
+		replyError(rw, req, storeError(err), err)
+		return
+	}
+	blob, _ := jsonEncodeUnescapedHTML(popdConfig)
+	rw.Write(blob)
+}
+
+func lookupUpfrontFare(c *uber.Client, rr *uber.EstimateRequest) (*uber.UpfrontFare, error) {
+	// Otherwise it is time to get the estimate of the fare
+	return c.UpfrontFare(rr)
+}
+
+type estimateAndUpfrontFarePair struct {
+	Estimate    *uber.PriceEstimate `json:"estimate"`
+	UpfrontFare *uber.UpfrontFare   `json:"upfront_fare"`
+	PickupTime  *uber.TimeEstimate  `json:"pickup_time,omitempty"`
+
+	product *product
+	fareErr error
+}
+
+const (
+	estimatesComplete = "complete"
+	estimatesPartial  = "partial"
+)
+
+// estimatesReply holds the estimates of a trip along with
+// what, if anything, kept some of them from being complete.
+type estimatesReply struct {
+	Status    string                        `json:"status"`
+	Estimates []*estimateAndUpfrontFarePair `json:"estimates"`
+	Errors    []*uberclick.Err              `json:"errors,omitempty"`
+}
+
+// estimateErrorMeta tells which product and
+// operation an error in an estimatesReply is about.
+type estimateErrorMeta struct {
+	Operation   string `json:"operation"`
+	ProductID   string `json:"product_id,omitempty"`
+	DisplayName string `json:"display_name,omitempty"`
+}
+
+// estimateRequest is an uber.EstimateRequest along
+// with how the estimates for it are to be shaped.
+type estimateRequest struct {
+	uber.EstimateRequest
+	uberclick.EstimateOptions
+
+	// APIKey if set selects the estimate
+	// defaults saved for its registration.
+	APIKey string `json:"api_key,omitempty"`
+}
+
+func (s *Server) estimatePrice(rw http.ResponseWriter, req *http.Request) {
+	s.withAuthToken(rw, req, func(token *oauth2.Token) {
+		esReq := new(estimateRequest)
+		if !s.readJSON(rw, req, esReq) {
+			return
+		}
+		if we := esReq.EstimateOptions.Validate(); we != nil {
+			replyErrors(rw, http.StatusBadRequest, we.Errors...)
+			return
+		}
+		trip, ok := s.validTrip(rw, req, &esReq.EstimateRequest, (*uberclick.Trip).ValidateEstimate)
+		if !ok {
+			return
+		}
+		opts, err := s.estimateOptions(req, esReq)
+		if err != nil {
+			replyError(rw, req, storeError(err), err)
+			return
+		}
+
+		// withAuthToken has ensured that the cookie is present.
+		user, _ := req.Cookie(cookieName)
+		key := estimateCacheKey(user.Value, trip) + "|" + estimateOptionsKey(opts)
+		ce, cached, err := s.cachedLookup(s.estimates, req, key, func(req *http.Request) (interface{}, bool, error) {
+			reply, err := s.lookupEstimates(req, token, &esReq.EstimateRequest, opts)
+			if err != nil {
+				return nil, false, err
+			}
+			// Partial results are not cached so that
+			// a retry gets another chance to complete.
+			return reply, reply.Status == estimatesComplete, nil
+		})
+		if err != nil {
+			replyError(rw, req, lookupError(err), err)
+			return
+		}
+		writeCached(rw, req, ce, cached)
+	})
+}
+
+// estimateOptions resolves the options of esReq, falling back to
+// the defaults of its API key and then to those of the Server.
+func (s *Server) estimateOptions(req *http.Request, esReq *estimateRequest) (*uberclick.EstimateOptions, error) {
+	var keyDefaults *uberclick.EstimateOptions
+	if esReq.APIKey != "" {
+		reg := &uberclick.RedisAPIKeyRegistration{APIKey: esReq.APIKey}
+		var err error
+		if keyDefaults, err = reg.EstimateDefaults(req.Context(), s.store); err != nil {
+			return nil, err
+		}
+	}
+	return esReq.EstimateOptions.Or(keyDefaults).Or(s.estimateDefaults), nil
+}
+
+// lookupEstimates pages through the price estimates for esReq, keeps
+// those of the product types in opts, ranks them by opts.SortBy and
+// looks up the upfront fares of the best ranked, returning at most
+// opts.Limit ranked again with the durations and pickup estimates
+// of their fares, if any.
+// Failures that leave some estimates usable are reported in the reply
+// rather than as an error.
+func (s *Server) lookupEstimates(req *http.Request, token *oauth2.Token, esReq *uber.EstimateRequest, opts *uberclick.EstimateOptions) (*estimatesReply, error) {
+	uberC, err := s.uberClient(req, token)
+	if err != nil {
+		return nil, err
+	}
+
+	var times []*uber.TimeEstimate
+	var timesErr error
+	timesDone := make(chan bool)
+	if needsTimes(opts) {
+		go func() {
+			defer close(timesDone)
+			times, timesErr = s.lookupTimes(req, uberC, esReq)
+		}()
+	} else {
+		close(timesDone)
+	}
+
+	end := s.startUpstream(req.Context(), upstreamEstimatePrice)
+	estimatesPageChan, cancelPaging, err := uberC.EstimatePrice(esReq)
+	if err != nil {
+		end(err)
+		return nil, err
+	}
+
+	var allEstimates []*uber.PriceEstimate
+	var pagingErr error
+	for page := range estimatesPageChan {
+		if page.Err == nil {
+			allEstimates = append(allEstimates, page.Estimates...)
+		} else if pagingErr == nil {
+			pagingErr = page.Err
+		}
+		if len(allEstimates) >= maxEstimateCandidates || req.Context().Err() != nil {
+			cancelPaging()
+		}
+	}
+	end(pagingErr)
+	if len(allEstimates) == 0 && pagingErr != nil {
+		<-timesDone
+		return nil, pagingErr
+	}
+	if len(allEstimates) > maxEstimateCandidates {
+		allEstimates = allEstimates[:maxEstimateCandidates]
+	}
+
+	var products map[string]*product
+	if needsProducts(opts) {
+		if products, err = s.lookupProducts(req, token, uberC, esReq); err != nil {
+			<-timesDone
+			return nil, err
+		}
+		var kept []*uber.PriceEstimate
+		for _, estimate := range allEstimates {
+			p := products[estimate.ProductID]
+			if p != nil && opts.HasProductType(p.ProductGroup, p.DisplayName) {
+				kept = append(kept, estimate)
+			}
+		}
+		allEstimates = kept
+	}
+
+	<-timesDone
+	timesByProduct := make(map[string]*uber.TimeEstimate)
+	for _, te := range times {
+		timesByProduct[te.ProductID] = te
+	}
+
+	// Upfront fares are costly to look up so only the candidates
+	// that their estimates rank near the top get them.
+	candidates := make([]*estimateAndUpfrontFarePair, len(allEstimates))
+	for i, estimate := range allEstimates {
+		candidates[i] = &estimateAndUpfrontFarePair{
+			Estimate:   estimate,
+			PickupTime: timesByProduct[estimate.ProductID],
+			product:    products[estimate.ProductID],
+		}
+	}
+	sortEstimates(candidates, opts.SortBy)
+	if n := opts.Limit + fareLookupMargin; len(candidates) > n {
+		candidates = candidates[:n]
+	}
+
+	jobsBench := make(chan semalim.Job)
+	go func() {
+		defer close(jobsBench)
+
+		for i, pair := range candidates {
+			jobsBench <- &lookupFare{
+				ctx:    req.Context(),
+				srv:    s,
+				client: uberC,
+				id:     i,
+				pair:   pair,
+				esReq: &uber.EstimateRequest{
+					StartLatitude:  esReq.StartLatitude,
+					StartLongitude: esReq.StartLongitude,
+					StartPlace:     esReq.StartPlace,
+					EndPlace:       esReq.EndPlace,
+					EndLatitude:    esReq.EndLatitude,
+					EndLongitude:   esReq.EndLongitude,
+					SeatCount:      esReq.SeatCount,
+					ProductID:      pair.Estimate.ProductID,
+				},
+			}
+		}
+	}()
+
+	var pairs []*estimateAndUpfrontFarePair
+	resChan := semalim.Run(jobsBench, 5)
+	for res := range resChan {
+		if retr := res.Value().(*estimateAndUpfrontFarePair); retr != nil {
+			retr.fareErr = res.Err()
+			pairs = append(pairs, retr)
+		}
+	}
+
+	// Fares arrive in whichever order their lookups finish.
+	sortEstimates(pairs, opts.SortBy)
+	if len(pairs) > opts.Limit {
+		pairs = pairs[:opts.Limit]
+	}
+
+	reply := &estimatesReply{Status: estimatesComplete, Estimates: pairs}
+	if pagingErr != nil {
+		reply.Errors = append(reply.Errors, upstreamError(pagingErr).err.WithMeta(&estimateErrorMeta{
+			Operation: upstreamEstimatePrice,
+		}))
+	}
+	if timesErr != nil {
+		reply.Errors = append(reply.Errors, lookupError(timesErr).err.WithMeta(&estimateErrorMeta{
+			Operation: upstreamEstimateTime,
+		}))
+	}
+	for _, pair := range pairs {
+		if pair.fareErr == nil {
+			continue
+		}
+		reply.Errors = append(reply.Errors, upstreamError(pair.fareErr).err.WithMeta(&estimateErrorMeta{
+			Operation:   upstreamUpfrontFare,
+			ProductID:   pair.Estimate.ProductID,
+			DisplayName: pair.Estimate.DisplayName,
+		}))
+	}
+	if len(reply.Errors) > 0 {
+		reply.Status = estimatesPartial
+		logging.FromContext(req.Context()).WarnContext(req.Context(), "partial estimates",
+			"estimates", len(pairs), "errors", len(reply.Errors), "paging_err", pagingErr, "times_err", timesErr)
+	}
+	return reply, nil
+}
+
+// lookupProducts returns the products offered
+// at the start of esReq keyed by their IDs.
+func (s *Server) lookupProducts(req *http.Request, token *oauth2.Token, uberC *uber.Client, esReq *uber.EstimateRequest) (map[string]*product, error) {
+	where, err := s.locateStart(req, uberC, esReq)
+	if err != nil {
+		return nil, err
+	}
+	ce, _, err := s.cachedProducts(req, token, where)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[string]*product)
+	for _, p := range ce.value.([]*product) {
+		byID[p.ProductID] = p
+	}
+	return byID, nil
+}
+
+type lookupFare struct {
+	ctx    context.Context
+	srv    *Server
+	id     int
+	pair   *estimateAndUpfrontFarePair
+	esReq  *uber.EstimateRequest
+	client *uber.Client
+}
+
+var _ semalim.Job = (*lookupFare)(nil)
+
+func (lf *lookupFare) Id() interface{} {
+	return lf.id
+}
+
+func (lf *lookupFare) Do() (interface{}, error) {
+	// Skip lookups queued behind the concurrency
+	// limit once the request has been abandoned.
+	if err := lf.ctx.Err(); err != nil {
+		return lf.pair, err
+	}
+	end := lf.srv.startUpstream(lf.ctx, upstreamUpfrontFare)
+	upfrontFare, err := lookupUpfrontFare(lf.client, &uber.EstimateRequest{
+		StartLatitude:  lf.esReq.StartLatitude,
+		StartLongitude: lf.esReq.StartLongitude,
+		StartPlace:     lf.esReq.StartPlace,
+		EndPlace:       lf.esReq.EndPlace,
+		EndLatitude:    lf.esReq.EndLatitude,
+		EndLongitude:   lf.esReq.EndLongitude,
+		SeatCount:      lf.esReq.SeatCount,
+		ProductID:      lf.pair.Estimate.ProductID,
+	})
+	end(err)
+	if err != nil {
+		// Whatever was decoded of a failed
+		// lookup must not be taken as a fare.
+		upfrontFare = nil
+	}
+
+	lf.pair.UpfrontFare = upfrontFare
+	return lf.pair, err
+}
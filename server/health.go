@@ -0,0 +1,147 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/odeke-em/uberclick"
+)
+
+// upstreamFreshness is how recent a successful call to the Uber
+// API must be for readiness to skip probing the API itself.
+const upstreamFreshness = 2 * time.Minute
+
+const healthKey = "uberclick-health"
+
+type checkResult struct {
+	Status    string         `json:"status"`
+	LatencyMs int64          `json:"latency_ms"`
+	Error     uberclick.Code `json:"error,omitempty"`
+}
+
+type healthReport struct {
+	Status string                  `json:"status"`
+	Checks map[string]*checkResult `json:"checks,omitempty"`
+}
+
+const (
+	statusOK          = "ok"
+	statusUnavailable = "unavailable"
+	statusDraining    = "draining"
+)
+
+// health tracks what readiness depends on
+// beyond what can be checked on demand.
+type health struct {
+	draining atomic.Bool
+	// lastUpstreamOK is the UnixNano time of the
+	// most recent successful call to the Uber API.
+	lastUpstreamOK atomic.Int64
+}
+
+func (s *Server) healthz(rw http.ResponseWriter, req *http.Request) {
+	writeHealth(rw, http.StatusOK, &healthReport{Status: statusOK})
+}
+
+func (s *Server) readyz(rw http.ResponseWriter, req *http.Request) {
+	if s.health.draining.Load() {
+		writeHealth(rw, http.StatusServiceUnavailable, &healthReport{Status: statusDraining})
+		return
+	}
+
+	checks := map[string]func(context.Context) *uberclick.Err{
+		"store":    s.checkStore,
+		"oauth2":   s.checkOAuth2,
+		"upstream": s.checkUpstream,
+	}
+	report := &healthReport{Status: statusOK, Checks: make(map[string]*checkResult)}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check func(context.Context) *uberclick.Err) {
+			defer wg.Done()
+
+			start := time.Now()
+			res := &checkResult{Status: statusOK}
+			if err := check(req.Context()); err != nil {
+				res.Status = statusUnavailable
+				res.Error = err.Code
+			}
+			res.LatencyMs = time.Since(start).Milliseconds()
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[name] = res
+			if res.Status != statusOK {
+				report.Status = statusUnavailable
+			}
+		}(name, check)
+	}
+	wg.Wait()
+
+	status := http.StatusOK
+	if report.Status != statusOK {
+		status = http.StatusServiceUnavailable
+	}
+	writeHealth(rw, status, report)
+}
+
+func writeHealth(rw http.ResponseWriter, status int, report *healthReport) {
+	blob, _ := jsonEncodeUnescapedHTML(report)
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("Cache-Control", "no-store")
+	rw.WriteHeader(status)
+	rw.Write(blob)
+}
+
+func (s *Server) checkStore(ctx context.Context) *uberclick.Err {
+	if _, err := s.store.SIsMember(ctx, healthKey, "ping"); err != nil {
+		return storeError(err).err
+	}
+	return nil
+}
+
+func (s *Server) checkOAuth2(ctx context.Context) *uberclick.Err {
+	if s.clientID == "" || s.clientSecret == "" || s.endpoint.AuthURL == "" || s.endpoint.TokenURL == "" {
+		return uberclick.ErrInternal
+	}
+	return nil
+}
+
+// checkUpstream trusts a recent successful call to the Uber API,
+// otherwise it checks that the token endpoint can be reached.
+// Any HTTP response at all is taken to mean that it can.
+func (s *Server) checkUpstream(ctx context.Context) *uberclick.Err {
+	if last := s.health.lastUpstreamOK.Load(); time.Since(time.Unix(0, last)) < upstreamFreshness {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.upstreamTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, s.endpoint.TokenURL, nil)
+	if err != nil {
+		return uberclick.ErrInternal
+	}
+	transport := s.transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	res, err := transport.RoundTrip(req)
+	if err != nil {
+		return upstreamError(err).err
+	}
+	res.Body.Close()
+	s.health.lastUpstreamOK.Store(time.Now().UnixNano())
+	return nil
+}
+
+// Drain makes /readyz fail so that load balancers stop routing
+// new requests to the Server. It is meant to be invoked at the
+// start of a graceful shutdown, before http.Server.Shutdown.
+func (s *Server) Drain() {
+	s.health.draining.Store(true)
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Stages of the OAuth2.0 grant funnel.
+const (
+	grantStarted        = "started"
+	grantCallback       = "callback_received"
+	grantExchangeFailed = "exchange_failed"
+	grantSucceeded      = "succeeded"
+)
+
+// Operations of the Uber API that are measured.
+const (
+	upstreamEstimatePrice = "estimate_price"
+	upstreamEstimateTime  = "estimate_time"
+	upstreamUpfrontFare   = "upfront_fare"
+	upstreamProfile       = "profile"
+	upstreamProducts      = "products"
+	upstreamPlace         = "place"
+	upstreamUpdatePlace   = "update_place"
+	upstreamTokenExchange = "token_exchange"
+)
+
+type metrics struct {
+	registry *prometheus.Registry
+
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+
+	upstreamDuration *prometheus.HistogramVec
+	upstreamErrors   *prometheus.CounterVec
+
+	storeDuration *prometheus.HistogramVec
+	storeErrors   *prometheus.CounterVec
+	reconnects    *prometheus.CounterVec
+
+	grants        *prometheus.CounterVec
+	expiredStates prometheus.Counter
+}
+
+func newMetrics(registry *prometheus.Registry) *metrics {
+	if registry == nil {
+		registry = prometheus.NewRegistry()
+		registry.MustRegister(
+			collectors.NewGoCollector(),
+			collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		)
+	}
+	m := &metrics{
+		registry: registry,
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "uberclick_http_requests_total",
+			Help: "HTTP requests served by route and status code.",
+		}, []string{"route", "code"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "uberclick_http_request_duration_seconds",
+			Help:    "Latency of HTTP requests by route.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"route"}),
+		upstreamDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "uberclick_upstream_request_duration_seconds",
+			Help:    "Latency of calls to the Uber API by operation.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"operation"}),
+		upstreamErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "uberclick_upstream_errors_total",
+			Help: "Failed calls to the Uber API by operation.",
+		}, []string{"operation"}),
+		storeDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "uberclick_store_operation_duration_seconds",
+			Help:    "Latency of store operations by operation.",
+			Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+		}, []string{"operation"}),
+		storeErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "uberclick_store_errors_total",
+			Help: "Failed store operations by operation, excluding misses.",
+		}, []string{"operation"}),
+		reconnects: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "uberclick_store_reconnects_total",
+			Help: "Attempts to reconnect to the store by result.",
+		}, []string{"result"}),
+		grants: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "uberclick_oauth2_grants_total",
+			Help: "OAuth2.0 grants by the stage of the funnel reached.",
+		}, []string{"stage"}),
+		expiredStates: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "uberclick_oauth2_states_expired_total",
+			Help: "OAuth2.0 states issued by this process that expired before their grant completed.",
+		}),
+	}
+	registry.MustRegister(
+		m.requests, m.requestDuration,
+		m.upstreamDuration, m.upstreamErrors,
+		m.storeDuration, m.storeErrors, m.reconnects,
+		m.grants, m.expiredStates,
+	)
+	for _, stage := range []string{grantStarted, grantCallback, grantExchangeFailed, grantSucceeded} {
+		m.grants.WithLabelValues(stage)
+	}
+	return m
+}
+
+// MetricsHandler returns the http.Handler serving the metrics of the
+// Server to Prometheus. It is not among the routes of Handler, so that
+// traffic and store health are only exposed where it is mounted.
+func (s *Server) MetricsHandler() http.Handler {
+	return promhttp.HandlerFor(s.metrics.registry, promhttp.HandlerOpts{})
+}
+
+// instrument counts and times the requests served by fn under route.
+func (m *metrics) instrument(route string, fn http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: rw, status: http.StatusOK}
+		fn(rec, req)
+		m.requestDuration.WithLabelValues(route).Observe(time.Since(start).Seconds())
+		m.requests.WithLabelValues(route, strconv.Itoa(rec.status)).Inc()
+	}
+}
+
+// observeUpstream records a call to the Uber API that began at start.
+func (m *metrics) observeUpstream(operation string, start time.Time, err error) {
+	m.upstreamDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	if err != nil {
+		m.upstreamErrors.WithLabelValues(operation).Inc()
+	}
+}
+
+func (m *metrics) grant(stage string) {
+	m.grants.WithLabelValues(stage).Inc()
+}
+
+// ObserveStoreReconnect records an attempt to reconnect the store
+// and is meant to be hooked up to store.Reconnecting.OnReconnect.
+func (s *Server) ObserveStoreReconnect(err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	s.metrics.reconnects.WithLabelValues(result).Inc()
+}
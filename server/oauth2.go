@@ -0,0 +1,264 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	"github.com/odeke-em/go-uuid"
+	uberOAuth2 "github.com/orijtech/uber/oauth2"
+	"github.com/orijtech/uber/v1"
+
+	"github.com/odeke-em/uberclick/logging"
+	"github.com/odeke-em/uberclick/store"
+)
+
+type authInfo struct {
+	URL string `json:"url"`
+}
+
+var oauth2Scopes = []string{
+	uberOAuth2.ScopeProfile,
+	uberOAuth2.ScopeHistory,
+	uberOAuth2.ScopePlaces,
+
+	// These scopes are privileged so make
+	// sure your application has them set.
+	uberOAuth2.ScopeRequest,
+	uberOAuth2.ScopeRequestReceipt,
+}
+
+func (s *Server) oauth2Config(req *http.Request) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     s.clientID,
+		ClientSecret: s.clientSecret,
+		Scopes:       oauth2Scopes,
+		Endpoint:     s.endpoint,
+		RedirectURL:  fmt.Sprintf("%s://%s/receive-oauth2", scheme(req), req.Host),
+	}
+}
+
+// oauth2Context makes the oauth2 package use
+// the Server's transport, if any, for token requests.
+func (s *Server) oauth2Context(ctx context.Context) context.Context {
+	if s.transport == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, oauth2.HTTPClient, &http.Client{Transport: s.transport})
+}
+
+// uberClient returns a client for the Uber API whose
+// calls are abandoned once req's context is done.
+func (s *Server) uberClient(req *http.Request, token *oauth2.Token) (*uber.Client, error) {
+	uberC, err := uber.NewClientFromOAuth2Token(token)
+	if err != nil {
+		return nil, err
+	}
+	ctx := req.Context()
+	uberC.SetHTTPRoundTripper(&oauth2.Transport{
+		Source: s.oauth2Config(req).TokenSource(s.oauth2Context(ctx), token),
+		Base:   &contextTransport{ctx: ctx, timeout: s.upstreamTimeout, base: s.transport},
+	})
+	return uberC, nil
+}
+
+// contextTransport binds outgoing requests to ctx, each with its own
+// deadline of timeout, since the Uber client does not accept contexts.
+type contextTransport struct {
+	ctx     context.Context
+	timeout time.Duration
+	base    http.RoundTripper
+}
+
+func (ct *contextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := ct.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	ctx, cancel := context.WithTimeout(ct.ctx, ct.timeout)
+	res, err := base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	// The deadline must outlive RoundTrip until the body is read.
+	res.Body = &cancelOnClose{ReadCloser: res.Body, cancel: cancel}
+	return res, nil
+}
+
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (cc *cancelOnClose) Close() error {
+	defer cc.cancel()
+	return cc.ReadCloser.Close()
+}
+
+func scheme(req *http.Request) string {
+	s := req.URL.Scheme
+	if s == "" {
+		s = "http"
+	}
+	return s
+}
+
+func (s *Server) grant(rw http.ResponseWriter, req *http.Request) {
+	// Freshly generate a nonce for any new submission
+	// as paranoia against reuse of nonces.
+	generatedNonce := uuid.NewRandom().String()
+	// Disabled/Commented out parsing of nonces from the outside
+	// itself because authorization and granting should use the same
+	// one but avoid reuse and corrupting when an attacker
+	// just copies another user's nonce and reuses it to get
+	// to their account.
+	// subm, err := uberclick.FparseSubmission(req.Body)
+	// if err != nil {
+	// 	http.Error(rw, err.Error(), http.StatusBadRequest)
+	// 	return
+	// }
+
+	config := s.oauth2Config(req)
+
+	nonce := uuid.NewRandom().String()
+	urlToVisit := config.AuthCodeURL(nonce, oauth2.AccessTypeOffline)
+	ai := &authInfo{URL: urlToVisit}
+	blob, err := jsonEncodeUnescapedHTML(ai)
+	if err != nil {
+		replyError(rw, req, errInternal, err)
+		return
+	}
+	if err := s.setState(req.Context(), nonce, generatedNonce); err != nil {
+		replyError(rw, req, storeError(err), err)
+		return
+	}
+	s.metrics.grant(grantStarted)
+	rw.Write(blob)
+}
+
+// oauth2Table holds the OAuth2.0 tokens keyed by nonce.
+const oauth2Table = "{uberclick-auth}oauth2-table"
+
+var errCacheMiss = store.ErrNotFound
+
+type redisOp int
+
+const (
+	opHPop redisOp = 1 + iota
+	opHGet
+)
+
+func (s *Server) saveOAuth2Token(ctx context.Context, key string, config *oauth2.Token) error {
+	blob, err := jsonEncodeUnescapedHTML(config)
+	if err != nil {
+		return err
+	}
+	return s.store.HSet(ctx, oauth2Table, key, blob)
+}
+
+func (s *Server) popOAuth2Config(ctx context.Context, key string) (*oauth2.Token, error) {
+	return s.retrieveOAuth2Config(ctx, key, opHPop)
+}
+
+func (s *Server) retrieveOAuth2Config(ctx context.Context, key string, op redisOp) (*oauth2.Token, error) {
+	var blob []byte
+	var err error
+
+	switch op {
+	case opHPop:
+		blob, err = s.store.HPop(ctx, oauth2Table, key)
+	default:
+		blob, err = s.store.HGet(ctx, oauth2Table, key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseOAuth2Config(blob)
+}
+
+func (s *Server) memoizedOAuth2Token(ctx context.Context, key string) (*oauth2.Token, error) {
+	return s.retrieveOAuth2Config(ctx, key, opHGet)
+}
+
+func parseOAuth2Config(blob []byte) (*oauth2.Token, error) {
+	token := new(oauth2.Token)
+	if err := json.Unmarshal(blob, token); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+func (s *Server) receiveUberAuth(rw http.ResponseWriter, req *http.Request) {
+	urlValues := req.URL.Query()
+	gotState := urlValues.Get("state")
+	s.metrics.grant(grantCallback)
+	state, err := s.popState(req.Context(), gotState)
+	s.logger.DebugContext(req.Context(), "consuming OAuth2.0 state", "state", logging.Secret(gotState), "err", err)
+	switch {
+	case err == nil:
+	case errors.Is(err, errReplayedState):
+		replyError(rw, req, errNonceReplayed, err)
+		return
+	case errors.Is(err, store.ErrNotFound), errors.Is(err, errMalformedState):
+		replyError(rw, req, errInvalidState, err)
+		return
+	default:
+		replyError(rw, req, storeError(err), err)
+		return
+	}
+
+	// wantState := state.Nonce
+	// if gotState != wantState {
+	// 	http.Error(rw, "states do not match", http.StatusUnauthorized)
+	// 	return
+	// }
+
+	code := urlValues.Get("code")
+	ctx, cancel := context.WithTimeout(s.oauth2Context(req.Context()), s.upstreamTimeout)
+	defer cancel()
+
+	config := s.oauth2Config(req)
+	end := s.startUpstream(ctx, upstreamTokenExchange)
+	token, err := config.Exchange(ctx, code)
+	end(err)
+	if err != nil {
+		s.metrics.grant(grantExchangeFailed)
+		replyError(rw, req, exchangeError(err), err)
+		return
+	}
+
+	nonce := state.Nonce
+	// Now save this OAuth2.0 config
+	// and attach it to the user account
+	if err := s.saveOAuth2Token(req.Context(), nonce, token); err != nil {
+		replyError(rw, req, storeError(err), err)
+		return
+	}
+
+	cookie := cookieFromOAuth2Token(token)
+	cookie.Name = cookieName
+	cookie.Value = nonce
+	http.SetCookie(rw, cookie)
+	s.metrics.grant(grantSucceeded)
+	s.logger.DebugContext(req.Context(), "authorized", "nonce", logging.Secret(nonce))
+	blob, _ := jsonEncodeUnescapedHTML(map[string]interface{}{"Success": true})
+	rw.Write(blob)
+}
+
+const (
+	cookieName = "uberclick-nonce"
+)
+
+func cookieFromOAuth2Token(token *oauth2.Token) *http.Cookie {
+	c := &http.Cookie{}
+	c.Expires = token.Expiry
+	c.MaxAge = int(c.Expires.Sub(time.Now()).Seconds())
+	return c
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/oauth2"
+
+	"github.com/odeke-em/uberclick"
+	"github.com/odeke-em/uberclick/geocode"
+)
+
+const (
+	defaultPlacesLimit = 5
+	maxPlacesLimit     = 10
+	maxQueryLength     = 200
+)
+
+type placesReply struct {
+	Places []*geocode.Place `json:"places"`
+}
+
+// searchPlaces resolves the address in the q query
+// parameter to the coordinates of matching places.
+func (s *Server) searchPlaces(rw http.ResponseWriter, req *http.Request) {
+	s.withAuthToken(rw, req, func(_ *oauth2.Token) {
+		if s.geocoder == nil {
+			replyError(rw, req, errGeocodingUnavailable, nil)
+			return
+		}
+
+		query := req.URL.Query()
+		q := strings.TrimSpace(query.Get("q"))
+		var errsList []*uberclick.Err
+		if q == "" || len(q) > maxQueryLength {
+			ev := *uberclick.ErrInvalidQuery
+			ev.Field = "q"
+			if q != "" {
+				ev.Details = fmt.Sprintf("expecting an address of at most %d bytes", maxQueryLength)
+			}
+			errsList = append(errsList, &ev)
+		}
+		limit := defaultPlacesLimit
+		if v := query.Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > maxPlacesLimit {
+				ev := *uberclick.ErrInvalidLimit
+				ev.Field = "limit"
+				ev.Details = fmt.Sprintf("expecting a limit between 1 and %d", maxPlacesLimit)
+				errsList = append(errsList, &ev)
+			} else {
+				limit = n
+			}
+		}
+		if len(errsList) > 0 {
+			replyErrors(rw, http.StatusBadRequest, errsList...)
+			return
+		}
+
+		ctx, span := s.tracer.Start(req.Context(), "geocode.search")
+		places, err := s.geocoder.Search(ctx, q, limit)
+		span.SetAttributes(attribute.Int("geocode.results", len(places)))
+		endSpan(span, err)
+		if err != nil {
+			replyError(rw, req, upstreamError(err), err)
+			return
+		}
+		if places == nil {
+			places = []*geocode.Place{}
+		}
+		blob, _ := jsonEncodeUnescapedHTML(&placesReply{Places: places})
+		rw.Write(blob)
+	})
+}
+
+// reversePlace resolves the latitude and longitude
+// query parameters to the address of the nearest place.
+func (s *Server) reversePlace(rw http.ResponseWriter, req *http.Request) {
+	s.withAuthToken(rw, req, func(_ *oauth2.Token) {
+		if s.geocoder == nil {
+			replyError(rw, req, errGeocodingUnavailable, nil)
+			return
+		}
+		where, ok := parseLocation(rw, req)
+		if !ok {
+			return
+		}
+
+		ctx, span := s.tracer.Start(req.Context(), "geocode.reverse")
+		place, err := s.geocoder.Reverse(ctx, where.Latitude, where.Longitude)
+		if errors.Is(err, geocode.ErrNotFound) {
+			span.End()
+			replyError(rw, req, errPlaceNotFound, nil)
+			return
+		}
+		endSpan(span, err)
+		if err != nil {
+			replyError(rw, req, upstreamError(err), err)
+			return
+		}
+		blob, _ := jsonEncodeUnescapedHTML(place)
+		rw.Write(blob)
+	})
+}
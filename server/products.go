@@ -0,0 +1,116 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	"github.com/orijtech/uber/v1"
+
+	"github.com/odeke-em/uberclick"
+)
+
+// DefaultProductsCacheTTL is how long the products
+// offered at a location are reused for any user.
+const DefaultProductsCacheTTL = 10 * time.Minute
+
+// product is a product of the Uber API along with
+// what it takes to present it as a choice.
+type product struct {
+	ProductID          string `json:"product_id"`
+	DisplayName        string `json:"display_name"`
+	Description        string `json:"description,omitempty"`
+	ProductGroup       string `json:"product_group,omitempty"`
+	Capacity           int    `json:"capacity"`
+	Image              string `json:"image,omitempty"`
+	Shared             bool   `json:"shared"`
+	CashEnabled        bool   `json:"cash_enabled"`
+	UpfrontFareEnabled bool   `json:"upfront_fare_enabled"`
+}
+
+type productsReply struct {
+	Products []*product `json:"products"`
+}
+
+// listProducts lists the products offered at the latitude
+// and longitude in the query, before any trip is planned.
+// Having no body, it is authorized by authorizeQueryDomain.
+func (s *Server) listProducts(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		rw.Header().Set("Allow", "GET")
+		replyError(rw, req, errMethodNotAllowed, nil)
+		return
+	}
+	if !s.authorizeQueryDomain(rw, req) {
+		return
+	}
+	s.withAuthToken(rw, req, func(token *oauth2.Token) {
+		where, ok := parseLocation(rw, req)
+		if !ok {
+			return
+		}
+		ce, cached, err := s.cachedProducts(req, token, where)
+		if err != nil {
+			replyError(rw, req, upstreamError(err), err)
+			return
+		}
+		reply := &productsReply{Products: ce.value.([]*product)}
+		writeCached(rw, req, &cachedEstimates{value: reply, at: ce.at}, cached)
+	})
+}
+
+// parseLocation reads the coordinates in the query of req,
+// replying to rw with every problem found if they are invalid.
+func parseLocation(rw http.ResponseWriter, req *http.Request) (*uber.Place, bool) {
+	query := req.URL.Query()
+	var errsList []*uberclick.Err
+	coord := func(name string) float64 {
+		f, err := strconv.ParseFloat(query.Get(name), 64)
+		if err != nil {
+			ev := *uberclick.ErrInvalidCoordinates
+			ev.Field = name
+			ev.Details = fmt.Sprintf("expecting a number, got %q", query.Get(name))
+			errsList = append(errsList, &ev)
+		}
+		return f
+	}
+	lat, lng := coord("latitude"), coord("longitude")
+	if len(errsList) == 0 {
+		if we := uberclick.ValidateLocation(lat, lng); we != nil {
+			errsList = we.Errors
+		}
+	}
+	if len(errsList) > 0 {
+		replyErrors(rw, http.StatusBadRequest, errsList...)
+		return nil, false
+	}
+	return &uber.Place{Latitude: lat, Longitude: lng}, true
+}
+
+// cachedProducts returns, as the value of an entry of the products
+// cache, the products offered at where. They are shared by all users
+// at nearby locations.
+func (s *Server) cachedProducts(req *http.Request, token *oauth2.Token, where *uber.Place) (*cachedEstimates, bool, error) {
+	r := roundCoordinate
+	key := fmt.Sprintf("%.*f,%.*f", coordinatePrecision, r(where.Latitude), coordinatePrecision, r(where.Longitude))
+	return s.cachedLookup(s.products, req, key, func(req *http.Request) (interface{}, bool, error) {
+		uberC, err := s.uberClient(req, token)
+		if err != nil {
+			return nil, false, err
+		}
+		end := s.startUpstream(req.Context(), upstreamProducts)
+		listed, err := uberC.ListProducts(where)
+		end(err)
+		if err != nil {
+			return nil, false, err
+		}
+		var products []*product
+		if err := transcode(listed, &products); err != nil {
+			return nil, false, err
+		}
+		return products, true, nil
+	})
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/odeke-em/go-uuid"
+
+	"github.com/odeke-em/uberclick/logging"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+// validRequestID restricts the request IDs taken from
+// clients to ones that are safe to log and echo back.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// withRequestLog attributes the logs of req to a request ID,
+// reported back in the X-Request-ID header, and logs its outcome.
+func (s *Server) withRequestLog(rw http.ResponseWriter, req *http.Request, next http.Handler) {
+	id := req.Header.Get(requestIDHeader)
+	if !validRequestID.MatchString(id) {
+		id = uuid.NewRandom().String()
+	}
+	rw.Header().Set(requestIDHeader, id)
+
+	ctx := logging.WithRequestID(req.Context(), id)
+	ctx = logging.NewContext(ctx, s.logger)
+
+	start := time.Now()
+	rec := &statusRecorder{ResponseWriter: rw, status: http.StatusOK}
+	next.ServeHTTP(rec, req.WithContext(ctx))
+
+	// The query is left out as it carries OAuth2.0 codes and states.
+	s.logger.InfoContext(ctx, "served request",
+		"method", req.Method,
+		"path", req.URL.Path,
+		"status", rec.status,
+		"bytes", rec.written,
+		"duration", time.Since(start),
+	)
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status  int
+	written int64
+}
+
+func (sr *statusRecorder) WriteHeader(status int) {
+	sr.status = status
+	sr.ResponseWriter.WriteHeader(status)
+}
+
+func (sr *statusRecorder) Write(b []byte) (int, error) {
+	n, err := sr.ResponseWriter.Write(b)
+	sr.written += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (sr *statusRecorder) Unwrap() http.ResponseWriter { return sr.ResponseWriter }
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"golang.org/x/oauth2"
+
+	"github.com/orijtech/uber/v1"
+
+	"github.com/odeke-em/uberclick"
+)
+
+type placeUpdate struct {
+	Address string `json:"address"`
+}
+
+// savedPlace serves the saved place of the user named id,
+// reading it on GET and replacing its address on PUT.
+func (s *Server) savedPlace(id uber.PlaceName) http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case http.MethodGet, http.MethodPut:
+		default:
+			rw.Header().Set("Allow", "GET, PUT")
+			replyError(rw, req, errMethodNotAllowed, nil)
+			return
+		}
+
+		s.withAuthToken(rw, req, func(token *oauth2.Token) {
+			var update *placeUpdate
+			if req.Method == http.MethodPut {
+				update = new(placeUpdate)
+				if !s.readJSON(rw, req, update) {
+					return
+				}
+				if update.Address = strings.TrimSpace(update.Address); update.Address == "" {
+					ev := *uberclick.ErrInvalidAddress
+					ev.Field = "address"
+					replyErrors(rw, http.StatusBadRequest, &ev)
+					return
+				}
+			}
+
+			uberC, err := s.uberClient(req, token)
+			if err != nil {
+				replyError(rw, req, errInternal, err)
+				return
+			}
+			var place *uber.Place
+			if update == nil {
+				end := s.startUpstream(req.Context(), upstreamPlace)
+				place, err = uberC.Place(id)
+				end(err)
+			} else {
+				end := s.startUpstream(req.Context(), upstreamUpdatePlace)
+				place, err = uberC.UpdatePlace(&uber.PlaceParams{Place: id, Address: update.Address})
+				end(err)
+				if err == nil {
+					// Estimates to or from the place
+					// were made for its old address.
+					user, _ := req.Cookie(cookieName)
+					s.estimates.forget(func(key string) bool {
+						return estimateCacheKeyHasPlace(key, user.Value, string(id))
+					})
+				}
+			}
+			if err != nil {
+				replyError(rw, req, upstreamError(err), err)
+				return
+			}
+			blob, _ := jsonEncodeUnescapedHTML(place)
+			rw.Write(blob)
+		})
+	}
+}
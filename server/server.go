@@ -0,0 +1,278 @@
+// Package server implements the uberclick HTTP API. A Server owns all of
+// its dependencies so that it can be constructed in tests with fakes for
+// the store, the OAuth2.0 endpoint and the Uber API.
+package server
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/oauth2"
+
+	uberOAuth2 "github.com/orijtech/uber/oauth2"
+
+	"github.com/odeke-em/uberclick"
+	"github.com/odeke-em/uberclick/geocode"
+	"github.com/odeke-em/uberclick/store"
+)
+
+type Options struct {
+	Store store.Store
+
+	OAuth2ClientID     string
+	OAuth2ClientSecret string
+
+	// OAuth2Endpoint if unset defaults to Uber's
+	// OAuth2.0 authorization and token URLs.
+	OAuth2Endpoint *oauth2.Endpoint
+
+	// Transport if set is used for the OAuth2.0 token exchange
+	// and for all calls to the Uber API, allowing them to be
+	// redirected to a fake server.
+	Transport http.RoundTripper
+
+	// StaticDir if set is served at the root path.
+	StaticDir string
+
+	// StoreTimeout bounds each store operation.
+	// It defaults to DefaultStoreTimeout.
+	StoreTimeout time.Duration
+
+	// UpstreamTimeout bounds each call to the Uber API and the
+	// OAuth2.0 token endpoint. It defaults to DefaultUpstreamTimeout.
+	UpstreamTimeout time.Duration
+
+	// StateTTL is how long OAuth2.0 states remain
+	// redeemable. It defaults to DefaultStateTTL.
+	StateTTL time.Duration
+
+	// Logger if set receives the logs of the Server.
+	// It defaults to slog's default logger.
+	Logger *slog.Logger
+
+	// Registry if set is where the metrics of the Server are
+	// registered and what MetricsHandler serves. By default a fresh
+	// registry with the Go runtime and process collectors is used.
+	Registry *prometheus.Registry
+
+	// EstimateCacheTTL is how long the estimates of a trip are
+	// reused for the same user. It defaults to DefaultEstimateCacheTTL
+	// and a negative value disables caching.
+	EstimateCacheTTL time.Duration
+
+	// ProductsCacheTTL is how long the products offered at a
+	// location are reused. It defaults to DefaultProductsCacheTTL
+	// and a negative value disables caching.
+	ProductsCacheTTL time.Duration
+
+	// Geocoder if set resolves addresses for /places/search and
+	// /places/reverse, which are otherwise unavailable.
+	Geocoder geocode.Geocoder
+
+	// EstimateDefaults are the options of estimates for which
+	// neither the request nor its API key say otherwise. Unset
+	// fields default to uberclick.DefaultEstimateLimit and
+	// uberclick.DefaultSortKey.
+	EstimateDefaults *uberclick.EstimateOptions
+
+	// TracerProvider if set is used to trace requests, store
+	// operations and calls to the Uber API. It defaults to the
+	// global provider of OpenTelemetry.
+	TracerProvider trace.TracerProvider
+
+	// Propagator if set extracts the trace context of incoming
+	// requests. It defaults to W3C Trace Context.
+	Propagator propagation.TextMapPropagator
+
+	// MaxBodyBytes caps the size of request bodies.
+	// It defaults to DefaultMaxBodyBytes.
+	MaxBodyBytes int64
+}
+
+const (
+	DefaultStoreTimeout    = 2 * time.Second
+	DefaultUpstreamTimeout = 10 * time.Second
+	DefaultMaxBodyBytes    = 64 << 10
+)
+
+type Server struct {
+	store store.Store
+
+	clientID     string
+	clientSecret string
+	endpoint     oauth2.Endpoint
+	transport    http.RoundTripper
+
+	upstreamTimeout time.Duration
+
+	stateTTL time.Duration
+	states   *stateLedger
+
+	maxBodyBytes int64
+
+	logger     *slog.Logger
+	metrics    *metrics
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+
+	health health
+
+	estimates        *estimateCache
+	products         *estimateCache
+	estimateDefaults *uberclick.EstimateOptions
+
+	geocoder geocode.Geocoder
+
+	mux *http.ServeMux
+
+	// ctx is cancelled by Close to abort
+	// upstream calls of in-flight requests.
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+var (
+	errNilStore       = errors.New("server: expecting a non-nil store")
+	errBlankOAuth2App = errors.New("server: expecting a non-blank OAuth2.0 client ID and secret")
+)
+
+func New(opts *Options) (*Server, error) {
+	if opts == nil || opts.Store == nil {
+		return nil, errNilStore
+	}
+	if opts.OAuth2ClientID == "" || opts.OAuth2ClientSecret == "" {
+		return nil, errBlankOAuth2App
+	}
+	if we := opts.EstimateDefaults.Validate(); we != nil {
+		return nil, we
+	}
+
+	storeTimeout := opts.StoreTimeout
+	if storeTimeout <= 0 {
+		storeTimeout = DefaultStoreTimeout
+	}
+	upstreamTimeout := opts.UpstreamTimeout
+	if upstreamTimeout <= 0 {
+		upstreamTimeout = DefaultUpstreamTimeout
+	}
+
+	tp := opts.TracerProvider
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	tracer := tp.Tracer(tracerName)
+
+	m := newMetrics(opts.Registry)
+	s := &Server{
+		store:           &deadlineStore{Store: opts.Store, timeout: storeTimeout, metrics: m, tracer: tracer},
+		metrics:         m,
+		tracer:          tracer,
+		propagator:      opts.Propagator,
+		clientID:        opts.OAuth2ClientID,
+		clientSecret:    opts.OAuth2ClientSecret,
+		transport:       opts.Transport,
+		upstreamTimeout: upstreamTimeout,
+		stateTTL:        opts.StateTTL,
+		states:          newStateLedger(),
+		maxBodyBytes:    opts.MaxBodyBytes,
+		logger:          opts.Logger,
+		geocoder:        opts.Geocoder,
+		endpoint: oauth2.Endpoint{
+			AuthURL:  uberOAuth2.OAuth2AuthURL,
+			TokenURL: uberOAuth2.OAuth2TokenURL,
+		},
+	}
+	if opts.OAuth2Endpoint != nil {
+		s.endpoint = *opts.OAuth2Endpoint
+	}
+	if s.stateTTL <= 0 {
+		s.stateTTL = DefaultStateTTL
+	}
+	if s.maxBodyBytes <= 0 {
+		s.maxBodyBytes = DefaultMaxBodyBytes
+	}
+	if s.logger == nil {
+		s.logger = slog.Default()
+	}
+	estimateCacheTTL := opts.EstimateCacheTTL
+	if estimateCacheTTL == 0 {
+		estimateCacheTTL = DefaultEstimateCacheTTL
+	}
+	s.estimates = newEstimateCache(estimateCacheTTL)
+	productsCacheTTL := opts.ProductsCacheTTL
+	if productsCacheTTL == 0 {
+		productsCacheTTL = DefaultProductsCacheTTL
+	}
+	s.products = newEstimateCache(productsCacheTTL)
+	s.estimateDefaults = opts.EstimateDefaults.Or(&uberclick.EstimateOptions{
+		Limit:  uberclick.DefaultEstimateLimit,
+		SortBy: uberclick.DefaultSortKey,
+	})
+	if s.propagator == nil {
+		s.propagator = propagation.TraceContext{}
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	go s.sweepStates(s.stateTTL / 2)
+
+	s.mux = http.NewServeMux()
+	if opts.StaticDir != "" {
+		s.mux.Handle("/", http.FileServer(http.Dir(opts.StaticDir)))
+	}
+	s.routes()
+	return s, nil
+}
+
+func (s *Server) routes() {
+	s.handle("/init", s.initAuth)
+	// This route registers acceptable domains
+	s.handle("/coruz", s.registerDomains)
+	s.handle("/grant", s.grant)
+	s.handle("/receive-oauth2", s.receiveUberAuth)
+	s.handle("/order", s.order)
+	s.handle("/estimate-price", s.estimatePrice)
+	s.handle("/estimate-time", s.estimateTime)
+	s.handle("/estimate-defaults", s.setEstimateDefaults)
+	s.handle("/profile", s.profile)
+	s.handle("/products", s.listProducts)
+	s.handle("/places/search", s.searchPlaces)
+	s.handle("/places/reverse", s.reversePlace)
+	s.handle("/places/home", s.savedPlace(uberclick.PlaceHome))
+	s.handle("/places/work", s.savedPlace(uberclick.PlaceWork))
+	s.handle("/deauth", s.deauth)
+	s.mux.HandleFunc("/healthz", s.healthz)
+	s.mux.HandleFunc("/readyz", s.readyz)
+}
+
+func (s *Server) handle(route string, fn http.HandlerFunc) {
+	s.mux.HandleFunc(route, s.metrics.instrument(route, s.traced(route, fn)))
+}
+
+// Handler returns the http.Handler serving all the uberclick routes.
+// The contexts of requests that it serves are cancelled by Close.
+func (s *Server) Handler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		ctx, cancel := context.WithCancel(req.Context())
+		defer cancel()
+		stop := context.AfterFunc(s.ctx, cancel)
+		defer stop()
+
+		s.withRequestLog(rw, req.WithContext(ctx), s.mux)
+	})
+}
+
+// Close cancels the upstream calls of any requests still in flight
+// and stops the background sweeping of expired OAuth2.0 states.
+// It is meant to be invoked after http.Server.Shutdown has had its
+// chance to drain them. The store is owned by the caller and is
+// not closed.
+func (s *Server) Close() error {
+	s.cancel()
+	return nil
+}
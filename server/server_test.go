@@ -0,0 +1,739 @@
+package server_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/odeke-em/uberclick"
+	"github.com/odeke-em/uberclick/geocode"
+	"github.com/odeke-em/uberclick/server"
+	"github.com/odeke-em/uberclick/store"
+	"github.com/odeke-em/uberclick/store/memstore"
+	"github.com/odeke-em/uberclick/uberfake"
+)
+
+const testOrigin = "https://shop.example.com"
+
+// harness serves a Server backed by memstore and
+// uberfake to a client that keeps its cookies.
+type harness struct {
+	t      *testing.T
+	fake   *uberfake.Server
+	srv    *server.Server
+	ts     *httptest.Server
+	client *http.Client
+}
+
+// newHarness starts a Server with opts, whose store, OAuth2.0 app
+// and upstream default to memstore and uberfake. configure, if
+// set, can adjust opts once the fake is running.
+func newHarness(t *testing.T, configure func(*server.Options)) *harness {
+	t.Helper()
+
+	fake := uberfake.New()
+	t.Cleanup(fake.Close)
+
+	opts := &server.Options{
+		Store:              memstore.New(),
+		OAuth2ClientID:     "client-id",
+		OAuth2ClientSecret: "client-secret",
+		OAuth2Endpoint:     fake.OAuth2Endpoint(),
+		Transport:          fake.Transport(),
+		Logger:             slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	if configure != nil {
+		configure(opts)
+	}
+	srv, err := server.New(opts)
+	if err != nil {
+		t.Fatalf("server.New: %v", err)
+	}
+	t.Cleanup(func() { srv.Close() })
+
+	ts := httptest.NewServer(srv.Handler())
+	t.Cleanup(ts.Close)
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &harness{t: t, fake: fake, srv: srv, ts: ts, client: &http.Client{Jar: jar}}
+}
+
+// do sends a request for path with body, if non-nil, as JSON
+// and returns the response along with its whole body.
+func (h *harness) do(method, path string, body interface{}) (*http.Response, []byte) {
+	h.t.Helper()
+	return h.send(h.request(method, path, body))
+}
+
+// request returns a request for path with body, if non-nil, as JSON.
+func (h *harness) request(method, path string, body interface{}) *http.Request {
+	h.t.Helper()
+
+	var r io.Reader
+	if body != nil {
+		blob, err := json.Marshal(body)
+		if err != nil {
+			h.t.Fatal(err)
+		}
+		r = bytes.NewReader(blob)
+	}
+	req, err := http.NewRequest(method, h.ts.URL+path, r)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return req
+}
+
+// send sends req and returns the response along with its whole body.
+func (h *harness) send(req *http.Request) (*http.Response, []byte) {
+	h.t.Helper()
+
+	res, err := h.client.Do(req)
+	if err != nil {
+		h.t.Fatalf("%s %s: %v", req.Method, req.URL.Path, err)
+	}
+	defer res.Body.Close()
+	blob, err := io.ReadAll(res.Body)
+	if err != nil {
+		h.t.Fatalf("%s %s: reading body: %v", req.Method, req.URL.Path, err)
+	}
+	return res, blob
+}
+
+// decode expects res to have status and decodes blob into recv.
+func (h *harness) decode(res *http.Response, blob []byte, status int, recv interface{}) {
+	h.t.Helper()
+
+	if res.StatusCode != status {
+		h.t.Fatalf("%s %s: status = %d, want %d; body: %s", res.Request.Method, res.Request.URL.Path, res.StatusCode, status, blob)
+	}
+	if recv == nil {
+		return
+	}
+	if err := json.Unmarshal(blob, recv); err != nil {
+		h.t.Fatalf("%s %s: decoding %s: %v", res.Request.Method, res.Request.URL.Path, blob, err)
+	}
+}
+
+// registerAPIKey registers testOrigin and returns its API key.
+func (h *harness) registerAPIKey() string {
+	h.t.Helper()
+
+	var reg struct {
+		APIKey string `json:"api_key"`
+	}
+	res, blob := h.do(http.MethodPost, "/coruz", []string{strings.TrimPrefix(testOrigin, "https://")})
+	h.decode(res, blob, http.StatusOK, &reg)
+	if reg.APIKey == "" {
+		h.t.Fatalf("/coruz returned no API key: %s", blob)
+	}
+	return reg.APIKey
+}
+
+// authorize completes the OAuth2.0 grant against the
+// fake, leaving the client with the session cookie.
+func (h *harness) authorize() {
+	h.t.Helper()
+
+	var ai struct {
+		URL string `json:"url"`
+	}
+	res, blob := h.do(http.MethodGet, "/grant", nil)
+	h.decode(res, blob, http.StatusOK, &ai)
+	if !strings.HasPrefix(ai.URL, h.fake.URL()) {
+		h.t.Fatalf("grant URL = %q, want one of the fake at %q", ai.URL, h.fake.URL())
+	}
+
+	// The fake consents at once and redirects back to /receive-oauth2.
+	res, err := h.client.Get(ai.URL)
+	if err != nil {
+		h.t.Fatalf("visiting the grant URL: %v", err)
+	}
+	defer res.Body.Close()
+	blob, _ = io.ReadAll(res.Body)
+	if res.StatusCode != http.StatusOK || res.Request.URL.Path != "/receive-oauth2" {
+		h.t.Fatalf("grant ended at %s with status %d: %s", res.Request.URL, res.StatusCode, blob)
+	}
+}
+
+// grantURL expects res to be the reply to a request without a
+// session and returns the URL where access can be granted.
+func (h *harness) grantURL(res *http.Response, blob []byte) string {
+	h.t.Helper()
+
+	var we struct {
+		Errors []struct {
+			Code string `json:"code"`
+			Meta struct {
+				URL string `json:"url"`
+			} `json:"meta"`
+		} `json:"errors"`
+	}
+	h.decode(res, blob, http.StatusUnauthorized, &we)
+	if len(we.Errors) != 1 || we.Errors[0].Code != "unauthenticated" {
+		h.t.Fatalf("%s %s = %s, want an unauthenticated error", res.Request.Method, res.Request.URL.Path, blob)
+	}
+	return we.Errors[0].Meta.URL
+}
+
+var testTrip = map[string]interface{}{
+	"start_latitude":  37.7752315,
+	"start_longitude": -122.418075,
+	"end_latitude":    37.7752415,
+	"end_longitude":   -122.518075,
+}
+
+type estimatesReply struct {
+	Status    string `json:"status"`
+	Estimates []struct {
+		Estimate struct {
+			ProductID   string `json:"product_id"`
+			DisplayName string `json:"display_name"`
+		} `json:"estimate"`
+		UpfrontFare *struct {
+			Fare struct {
+				FareID string  `json:"fare_id"`
+				Value  float64 `json:"value"`
+			} `json:"fare"`
+		} `json:"upfront_fare"`
+	} `json:"estimates"`
+	Errors []json.RawMessage `json:"errors"`
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name string
+		opts *server.Options
+	}{
+		{name: "no options"},
+		{name: "no store", opts: &server.Options{OAuth2ClientID: "client-id", OAuth2ClientSecret: "client-secret"}},
+		{name: "no OAuth2.0 app", opts: &server.Options{Store: memstore.New()}},
+	}
+	for _, tt := range tests {
+		if _, err := server.New(tt.opts); err == nil {
+			t.Errorf("%s: New succeeded, want an error", tt.name)
+		}
+	}
+}
+
+// TestWidgetFlow walks through what the widget and map.html do:
+// initialize with an API key, get sent to the grant, come back
+// authorized, then estimate a trip and order one of its products.
+func TestWidgetFlow(t *testing.T) {
+	h := newHarness(t, nil)
+	apiKey := h.registerAPIKey()
+	login := map[string]string{"api_key": apiKey, "origin": testOrigin}
+
+	res, blob := h.do(http.MethodPost, "/init", login)
+	h.decode(res, blob, http.StatusOK, nil)
+
+	// Without a session the profile points at the grant.
+	res, blob = h.do(http.MethodPost, "/profile", login)
+	if got := h.grantURL(res, blob); got != h.ts.URL+"/grant" {
+		t.Fatalf("unauthorized /profile URL = %q, want %q", got, h.ts.URL+"/grant")
+	}
+
+	h.authorize()
+
+	var profile struct {
+		FirstName string `json:"first_name"`
+	}
+	res, blob = h.do(http.MethodPost, "/profile", login)
+	h.decode(res, blob, http.StatusOK, &profile)
+	if profile.FirstName != "Uber" {
+		t.Errorf("profile first name = %q, want %q", profile.FirstName, "Uber")
+	}
+
+	var estimates estimatesReply
+	res, blob = h.do(http.MethodPost, "/estimate-price", testTrip)
+	h.decode(res, blob, http.StatusOK, &estimates)
+	if estimates.Status != "complete" || len(estimates.Errors) != 0 {
+		t.Fatalf("estimates status = %q with errors %s, want complete", estimates.Status, blob)
+	}
+	var gotIDs []string
+	for _, pair := range estimates.Estimates {
+		gotIDs = append(gotIDs, pair.Estimate.ProductID)
+		if pair.UpfrontFare == nil || pair.UpfrontFare.Fare.FareID == "" {
+			t.Errorf("estimate of %s has no upfront fare", pair.Estimate.ProductID)
+		}
+	}
+	// Ordered by price, cheapest first.
+	wantIDs := []string{"pool-fake", "uberx-fake", "uberxl-fake"}
+	if strings.Join(gotIDs, ",") != strings.Join(wantIDs, ",") {
+		t.Fatalf("estimated products = %v, want %v", gotIDs, wantIDs)
+	}
+	if got := h.fake.Calls(uberfake.RouteUpfrontFare); got != len(wantIDs) {
+		t.Errorf("upfront fare lookups = %d, want %d", got, len(wantIDs))
+	}
+
+	// Identical estimates are served from the cache.
+	res, blob = h.do(http.MethodPost, "/estimate-price", testTrip)
+	h.decode(res, blob, http.StatusOK, nil)
+	if got := res.Header.Get("X-Cache"); got != "HIT" {
+		t.Errorf("repeated estimate X-Cache = %q, want HIT", got)
+	}
+
+	order := map[string]interface{}{"product_id": estimates.Estimates[0].Estimate.ProductID}
+	for k, v := range testTrip {
+		order[k] = v
+	}
+	res, blob = h.do(http.MethodPost, "/order", order)
+	h.decode(res, blob, http.StatusOK, nil)
+	if got := h.fake.Calls(uberfake.RouteRideRequest); got != 0 {
+		t.Errorf("rides requested = %d, want none while ordering is stubbed", got)
+	}
+}
+
+func TestEstimatesWithoutSession(t *testing.T) {
+	h := newHarness(t, nil)
+
+	res, blob := h.do(http.MethodPost, "/estimate-price", testTrip)
+	if got := h.grantURL(res, blob); got == "" {
+		t.Fatalf("estimates without a session gave no grant URL: %s", blob)
+	}
+	if got := h.fake.Calls(uberfake.RoutePriceEstimates); got != 0 {
+		t.Errorf("price estimates looked up = %d, want none", got)
+	}
+
+	// A cookie of a session that this server never
+	// saw is as good as none at all.
+	u, _ := url.Parse(h.ts.URL)
+	h.client.Jar.SetCookies(u, []*http.Cookie{{Name: "uberclick-nonce", Value: "unknown-nonce"}})
+	res, blob = h.do(http.MethodPost, "/estimate-price", testTrip)
+	if got := h.grantURL(res, blob); got == "" {
+		t.Fatalf("estimates with an unknown session gave no grant URL: %s", blob)
+	}
+}
+
+func TestUnknownProductUpfrontFare(t *testing.T) {
+	h := newHarness(t, nil)
+	h.authorize()
+
+	// The price estimates quote a product that has since
+	// been withdrawn so its upfront fare cannot be found.
+	products := h.fake.Products()
+	withdrawn := products[0]
+	h.fake.SetProducts(products[1:]...)
+	h.fake.Script(uberfake.RoutePriceEstimates, &uberfake.Response{
+		Body: map[string]interface{}{"prices": []map[string]interface{}{
+			{"product_id": withdrawn.ID, "display_name": withdrawn.DisplayName, "low_estimate": withdrawn.LowEstimate},
+		}},
+	})
+
+	var estimates estimatesReply
+	res, blob := h.do(http.MethodPost, "/estimate-price", testTrip)
+	h.decode(res, blob, http.StatusOK, &estimates)
+	if estimates.Status != "partial" || len(estimates.Errors) != 1 {
+		t.Fatalf("estimates = %s, want a partial reply with one error", blob)
+	}
+	if len(estimates.Estimates) != 1 || estimates.Estimates[0].UpfrontFare != nil {
+		t.Errorf("estimates = %s, want the withdrawn product without a fare", blob)
+	}
+}
+
+func TestInitRefusesOrigins(t *testing.T) {
+	h := newHarness(t, nil)
+	apiKey := h.registerAPIKey()
+
+	tests := []struct {
+		name, apiKey, origin string
+		status               int
+		code                 string
+	}{
+		{"registered", apiKey, testOrigin, http.StatusOK, ""},
+		{"other origin", apiKey, "https://elsewhere.example.com", http.StatusForbidden, "domain_not_allowed"},
+		{"unknown key", "unknown-" + apiKey, testOrigin, http.StatusForbidden, "unknown_api_key"},
+	}
+	for _, tt := range tests {
+		var we struct {
+			Errors []struct {
+				Code string `json:"code"`
+			} `json:"errors"`
+		}
+		res, blob := h.do(http.MethodPost, "/init", map[string]string{"api_key": tt.apiKey, "origin": tt.origin})
+		if tt.code == "" {
+			h.decode(res, blob, tt.status, nil)
+			continue
+		}
+		h.decode(res, blob, tt.status, &we)
+		if len(we.Errors) != 1 || we.Errors[0].Code != tt.code {
+			t.Errorf("%s: /init = %s, want %s", tt.name, blob, tt.code)
+		}
+	}
+}
+
+// TestReplayedGrantAcrossInstances replays a completed OAuth2.0
+// callback against another instance sharing the same store.
+func TestReplayedGrantAcrossInstances(t *testing.T) {
+	var st store.Store
+	a := newHarness(t, func(opts *server.Options) { st = opts.Store })
+	b := newHarness(t, func(opts *server.Options) { opts.Store = st })
+
+	var ai struct {
+		URL string `json:"url"`
+	}
+	res, blob := a.do(http.MethodGet, "/grant", nil)
+	a.decode(res, blob, http.StatusOK, &ai)
+
+	// Stop at the redirect back so that its URL can be replayed.
+	noFollow := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	res, err := noFollow.Get(ai.URL)
+	if err != nil {
+		t.Fatalf("visiting the grant URL: %v", err)
+	}
+	res.Body.Close()
+	callback, err := res.Location()
+	if err != nil {
+		t.Fatalf("grant redirect: %v", err)
+	}
+	path := callback.Path + "?" + callback.RawQuery
+
+	res, blob = a.do(http.MethodGet, path, nil)
+	a.decode(res, blob, http.StatusOK, nil)
+
+	for _, h := range []*harness{b, a} {
+		var we struct {
+			Errors []struct {
+				Code string `json:"code"`
+			} `json:"errors"`
+		}
+		res, blob = h.do(http.MethodGet, path, nil)
+		h.decode(res, blob, http.StatusConflict, &we)
+		if len(we.Errors) != 1 || we.Errors[0].Code != "nonce_replayed" {
+			t.Errorf("replayed callback = %s, want nonce_replayed", blob)
+		}
+	}
+}
+
+// TestMetricsHandler checks that metrics are kept off the public
+// routes and served only where MetricsHandler is mounted.
+func TestMetricsHandler(t *testing.T) {
+	h := newHarness(t, nil)
+	h.registerAPIKey()
+
+	res, blob := h.do(http.MethodGet, "/metrics", nil)
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("public /metrics = %d %s, want %d", res.StatusCode, blob, http.StatusNotFound)
+	}
+
+	rec := httptest.NewRecorder()
+	h.srv.MetricsHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("MetricsHandler = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if body := rec.Body.String(); !strings.Contains(body, "uberclick_http_requests_total") {
+		t.Errorf("MetricsHandler served no request counts:\n%s", body)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	h := newHarness(t, nil)
+
+	var report struct {
+		Status string `json:"status"`
+	}
+	res, blob := h.do(http.MethodGet, "/readyz", nil)
+	h.decode(res, blob, http.StatusOK, &report)
+	if report.Status != "ok" {
+		t.Fatalf("/readyz before draining = %s, want ok", blob)
+	}
+
+	h.srv.Drain()
+	res, blob = h.do(http.MethodGet, "/readyz", nil)
+	h.decode(res, blob, http.StatusServiceUnavailable, &report)
+	if report.Status != "draining" {
+		t.Errorf("/readyz while draining = %s, want draining", blob)
+	}
+
+	// Requests are still served until load balancers catch up.
+	res, blob = h.do(http.MethodGet, "/healthz", nil)
+	h.decode(res, blob, http.StatusOK, nil)
+	h.registerAPIKey()
+}
+
+// TestEstimatesLookUpFewFares checks that upfront fares are only
+// looked up for the products whose estimates rank near the top, and
+// that prices stay ranked by their low estimates once fares are known.
+func TestEstimatesLookUpFewFares(t *testing.T) {
+	h := newHarness(t, nil)
+	h.authorize()
+
+	var products []*uberfake.Product
+	for i := 0; i < 8; i++ {
+		p := *uberfake.DefaultProducts()[0]
+		p.ID = fmt.Sprintf("uberx-%d-fake", i)
+		p.DisplayName = fmt.Sprintf("uberX %d", i)
+		// The later products are cheaper, though the
+		// upfront fares of the two cheapest disagree.
+		p.LowEstimate = float64(20 - i)
+		p.UpfrontFareUSD = float64(20 - i)
+		products = append(products, &p)
+	}
+	products[7].UpfrontFareUSD, products[6].UpfrontFareUSD = 14, 13
+	h.fake.SetProducts(products...)
+
+	trip := map[string]interface{}{"limit": 1}
+	for k, v := range testTrip {
+		trip[k] = v
+	}
+	var estimates estimatesReply
+	res, blob := h.do(http.MethodPost, "/estimate-price", trip)
+	h.decode(res, blob, http.StatusOK, &estimates)
+	if len(estimates.Estimates) != 1 || estimates.Estimates[0].Estimate.ProductID != "uberx-7-fake" {
+		t.Fatalf("estimates = %s, want only the cheapest low estimate of uberx-7-fake", blob)
+	}
+	if got, want := h.fake.Calls(uberfake.RouteUpfrontFare), 3; got != want {
+		t.Errorf("upfront fare lookups = %d, want %d for the limit and its margin", got, want)
+	}
+}
+
+// TestPickupTimesOverride checks that a request can turn off
+// the pickup times that the defaults of its API key turn on.
+func TestPickupTimesOverride(t *testing.T) {
+	h := newHarness(t, nil)
+	apiKey := h.registerAPIKey()
+	h.authorize()
+
+	defaults := map[string]interface{}{"api_key": apiKey, "origin": testOrigin, "pickup_times": true}
+	res, blob := h.do(http.MethodPost, "/estimate-defaults", defaults)
+	h.decode(res, blob, http.StatusOK, nil)
+
+	tests := []struct {
+		name        string
+		pickupTimes interface{}
+		wantCalls   int
+	}{
+		{name: "turned off", pickupTimes: false, wantCalls: 0},
+		{name: "left to the defaults", wantCalls: 1},
+	}
+	for _, tt := range tests {
+		trip := map[string]interface{}{"api_key": apiKey}
+		for k, v := range testTrip {
+			trip[k] = v
+		}
+		if tt.pickupTimes != nil {
+			trip["pickup_times"] = tt.pickupTimes
+		}
+		res, blob := h.do(http.MethodPost, "/estimate-price", trip)
+		h.decode(res, blob, http.StatusOK, nil)
+		if got := h.fake.Calls(uberfake.RouteTimeEstimates); got != tt.wantCalls {
+			t.Errorf("%s: time estimates looked up = %d, want %d", tt.name, got, tt.wantCalls)
+		}
+	}
+}
+
+// TestTimeEstimatesPerProduct checks that the pickup times
+// cached for one product are not answered for another.
+func TestTimeEstimatesPerProduct(t *testing.T) {
+	h := newHarness(t, nil)
+	h.authorize()
+
+	for i, p := range h.fake.Products()[:2] {
+		where := map[string]interface{}{
+			"start_latitude":  testTrip["start_latitude"],
+			"start_longitude": testTrip["start_longitude"],
+			"product_id":      p.ID,
+		}
+		var reply struct {
+			Times []struct {
+				ProductID string `json:"product_id"`
+			} `json:"times"`
+		}
+		res, blob := h.do(http.MethodPost, "/estimate-time", where)
+		h.decode(res, blob, http.StatusOK, &reply)
+		if len(reply.Times) != 1 || reply.Times[0].ProductID != p.ID {
+			t.Errorf("/estimate-time for %s = %s, want only its pickup time", p.ID, blob)
+		}
+		if got := h.fake.Calls(uberfake.RouteTimeEstimates); got != i+1 {
+			t.Errorf("time estimates looked up = %d after %s, want %d", got, p.ID, i+1)
+		}
+	}
+}
+
+// TestSavedPlaceStart checks that a saved start is geocoded from
+// its address, since Uber does not give its coordinates.
+func TestSavedPlaceStart(t *testing.T) {
+	home := &geocode.Place{Address: uberfake.SavedPlaces[uberclick.PlaceHome], Latitude: 37.7825, Longitude: -122.4088}
+	tests := []struct {
+		name     string
+		geocoder geocode.Geocoder
+		status   int
+		code     string
+	}{
+		{"geocoded", geocode.NewStatic(home), http.StatusOK, ""},
+		{"unknown address", geocode.NewStatic(), http.StatusUnprocessableEntity, "unlocatable_place"},
+		{"no geocoder", nil, http.StatusNotImplemented, "geocoding_unavailable"},
+	}
+	for _, tt := range tests {
+		h := newHarness(t, func(opts *server.Options) { opts.Geocoder = tt.geocoder })
+		h.authorize()
+
+		var we struct {
+			Errors []struct {
+				Code string `json:"code"`
+			} `json:"errors"`
+		}
+		res, blob := h.do(http.MethodPost, "/estimate-time", map[string]string{"start_place_id": uberclick.PlaceHome})
+		if tt.code == "" {
+			h.decode(res, blob, tt.status, nil)
+			if got := h.fake.Calls(uberfake.RouteTimeEstimates); got != 1 {
+				t.Errorf("%s: time estimates looked up = %d, want 1", tt.name, got)
+			}
+			continue
+		}
+		h.decode(res, blob, tt.status, &we)
+		if len(we.Errors) != 1 || we.Errors[0].Code != tt.code {
+			t.Errorf("%s: /estimate-time = %s, want %s", tt.name, blob, tt.code)
+		}
+		if got := h.fake.Calls(uberfake.RouteTimeEstimates); got != 0 {
+			t.Errorf("%s: time estimates looked up = %d, want none at 0,0", tt.name, got)
+		}
+	}
+}
+
+// TestSavedPlaceUpdate checks that changing a saved place
+// forgets only the cached estimates of trips to or from it.
+func TestSavedPlaceUpdate(t *testing.T) {
+	const moved = "1 Market St, San Francisco"
+	geocoder := geocode.NewStatic(
+		&geocode.Place{Address: uberfake.SavedPlaces[uberclick.PlaceHome], Latitude: 37.7825, Longitude: -122.4088},
+		&geocode.Place{Address: moved, Latitude: 37.7941, Longitude: -122.3951},
+	)
+	h := newHarness(t, func(opts *server.Options) { opts.Geocoder = geocoder })
+	h.authorize()
+
+	fromHome := map[string]interface{}{"start_place_id": uberclick.PlaceHome}
+	fromHere := map[string]interface{}{
+		"start_latitude":  testTrip["start_latitude"],
+		"start_longitude": testTrip["start_longitude"],
+	}
+	steps := []struct {
+		name      string
+		method    string
+		path      string
+		body      interface{}
+		wantCalls int
+	}{
+		{"from home", http.MethodPost, "/estimate-time", fromHome, 1},
+		{"from here", http.MethodPost, "/estimate-time", fromHere, 2},
+		{"moving work", http.MethodPut, "/places/work", map[string]string{"address": moved}, 2},
+		{"from home after moving work", http.MethodPost, "/estimate-time", fromHome, 2},
+		{"moving home", http.MethodPut, "/places/home", map[string]string{"address": moved}, 2},
+		{"from home after moving it", http.MethodPost, "/estimate-time", fromHome, 3},
+		{"from here after moving home", http.MethodPost, "/estimate-time", fromHere, 3},
+	}
+	for _, step := range steps {
+		res, blob := h.do(step.method, step.path, step.body)
+		h.decode(res, blob, http.StatusOK, nil)
+		if got := h.fake.Calls(uberfake.RouteTimeEstimates); got != step.wantCalls {
+			t.Errorf("%s: time estimates looked up = %d, want %d", step.name, got, step.wantCalls)
+		}
+	}
+}
+
+func TestSearchPlaces(t *testing.T) {
+	geocoder := geocode.NewStatic(
+		&geocode.Place{Address: "1 Market St, San Francisco", Latitude: 37.7941, Longitude: -122.3951},
+		&geocode.Place{Address: "2 Market St, San Francisco", Latitude: 37.7939, Longitude: -122.3954},
+	)
+	h := newHarness(t, func(opts *server.Options) { opts.Geocoder = geocoder })
+	h.authorize()
+
+	tests := []struct {
+		query  string
+		status int
+		places int
+		code   string
+	}{
+		{query: "q=market", status: http.StatusOK, places: 2},
+		{query: "q=market&limit=1", status: http.StatusOK, places: 1},
+		{query: "q=market&limit=0", status: http.StatusBadRequest, code: "invalid_limit"},
+		{query: "q=market&limit=many", status: http.StatusBadRequest, code: "invalid_limit"},
+		{query: "limit=1", status: http.StatusBadRequest, code: "invalid_query"},
+	}
+	for _, tt := range tests {
+		var reply struct {
+			Places []*geocode.Place `json:"places"`
+			Errors []struct {
+				Code string `json:"code"`
+			} `json:"errors"`
+		}
+		res, blob := h.do(http.MethodGet, "/places/search?"+tt.query, nil)
+		h.decode(res, blob, tt.status, &reply)
+		if tt.code != "" {
+			if len(reply.Errors) != 1 || reply.Errors[0].Code != tt.code {
+				t.Errorf("/places/search?%s = %s, want %s", tt.query, blob, tt.code)
+			}
+			continue
+		}
+		if len(reply.Places) != tt.places {
+			t.Errorf("/places/search?%s = %s, want %d places", tt.query, blob, tt.places)
+		}
+	}
+}
+
+func TestProducts(t *testing.T) {
+	h := newHarness(t, nil)
+	apiKey := h.registerAPIKey()
+	h.authorize()
+
+	const where = "/products?latitude=37.7752315&longitude=-122.418075"
+	var reply struct {
+		Products []struct {
+			ProductID string `json:"product_id"`
+		} `json:"products"`
+	}
+	res, blob := h.do(http.MethodGet, where+"&api_key="+url.QueryEscape(apiKey)+"&origin="+url.QueryEscape(testOrigin), nil)
+	h.decode(res, blob, http.StatusOK, &reply)
+	if len(reply.Products) != len(h.fake.Products()) {
+		t.Errorf("products = %s, want the %d of the fake", blob, len(h.fake.Products()))
+	}
+
+	// The widget may send its key and origin as headers instead.
+	req := h.request(http.MethodGet, where, nil)
+	req.Header.Set("X-API-Key", apiKey)
+	req.Header.Set("Origin", testOrigin)
+	res, blob = h.send(req)
+	h.decode(res, blob, http.StatusOK, nil)
+	if got := res.Header.Get("X-Cache"); got != "HIT" {
+		t.Errorf("repeated products X-Cache = %q, want HIT", got)
+	}
+
+	tests := []struct {
+		method, path string
+		status       int
+		code         string
+	}{
+		{http.MethodGet, where + "&origin=" + url.QueryEscape(testOrigin), http.StatusBadRequest, "invalid_api_key"},
+		{http.MethodGet, where + "&api_key=" + url.QueryEscape(apiKey), http.StatusForbidden, "domain_not_allowed"},
+		{http.MethodPost, where, http.StatusMethodNotAllowed, "method_not_allowed"},
+	}
+	for _, tt := range tests {
+		var we struct {
+			Errors []struct {
+				Code string `json:"code"`
+			} `json:"errors"`
+		}
+		res, blob := h.do(tt.method, tt.path, nil)
+		h.decode(res, blob, tt.status, &we)
+		if len(we.Errors) != 1 || we.Errors[0].Code != tt.code {
+			t.Errorf("%s %s = %s, want %s", tt.method, tt.path, blob, tt.code)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/odeke-em/uberclick/store"
+)
+
+// DefaultStateTTL is how long a user has to complete
+// the OAuth2.0 grant before its state expires.
+const DefaultStateTTL = 10 * time.Minute
+
+// oauth2State is stored under the state parameter of an
+// authorization URL until the user returns with it.
+type oauth2State struct {
+	Nonce    string `json:"nonce"`
+	IssuedAt int64  `json:"issued_at"`
+}
+
+var (
+	errMalformedState = errors.New("server: malformed OAuth2.0 state")
+	errReplayedState  = errors.New("server: replayed OAuth2.0 state")
+)
+
+func decodeState(blob []byte) (*oauth2State, error) {
+	st := new(oauth2State)
+	if err := json.Unmarshal(blob, st); err != nil || st.Nonce == "" {
+		return nil, errMalformedState
+	}
+	return st, nil
+}
+
+// Each state lives in its own key, hash tagged by the state
+// itself so that its tombstone lands on the same cluster slot.
+func stateKey(state string) string         { return "uberclick-state:{" + state + "}" }
+func consumedStateKey(state string) string { return "uberclick-state-consumed:{" + state + "}" }
+
+func (s *Server) setState(ctx context.Context, state, nonce string) error {
+	blob, err := json.Marshal(&oauth2State{Nonce: nonce, IssuedAt: time.Now().Unix()})
+	if err != nil {
+		return err
+	}
+	if err := s.store.SetEx(ctx, stateKey(state), blob, s.stateTTL); err != nil {
+		return err
+	}
+	s.states.issued(state, time.Now().Add(s.stateTTL))
+	return nil
+}
+
+// popState atomically consumes state so that
+// a replayed callback cannot reuse it.
+func (s *Server) popState(ctx context.Context, state string) (*oauth2State, error) {
+	blob, err := s.store.GetDel(ctx, stateKey(state))
+	if errors.Is(err, store.ErrNotFound) && s.replayed(ctx, state) {
+		return nil, errReplayedState
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.states.consumed(state)
+	// The tombstone lets every instance recognise replays and
+	// tells the sweeper of the issuing one, if another, that
+	// the state did not expire unused.
+	if err := s.store.SetEx(ctx, consumedStateKey(state), []byte("1"), 2*s.stateTTL); err != nil {
+		s.logger.WarnContext(ctx, "recording consumed state", "err", err)
+	}
+	return decodeState(blob)
+}
+
+// replayed reports whether state was already consumed,
+// whether here or, per its tombstone, by another instance.
+func (s *Server) replayed(ctx context.Context, state string) bool {
+	if s.states.replayed(state) {
+		return true
+	}
+	// Unlike GetDel, Expire finds the tombstone without
+	// consuming it so that later replays are caught too.
+	return s.store.Expire(ctx, consumedStateKey(state), 2*s.stateTTL) == nil
+}
+
+// stateLedger tracks the states issued by this process
+// so that the ones that expire unconsumed can be counted,
+// and the ones consumed can be recognised if replayed.
+type stateLedger struct {
+	mu      sync.Mutex
+	pending map[string]time.Time
+	spent   map[string]time.Time
+}
+
+func newStateLedger() *stateLedger {
+	return &stateLedger{
+		pending: make(map[string]time.Time),
+		spent:   make(map[string]time.Time),
+	}
+}
+
+func (sl *stateLedger) issued(state string, deadline time.Time) {
+	sl.mu.Lock()
+	sl.pending[state] = deadline
+	sl.mu.Unlock()
+}
+
+// consumed reports whether state had been issued by this process.
+func (sl *stateLedger) consumed(state string) bool {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+
+	deadline, ok := sl.pending[state]
+	if ok {
+		delete(sl.pending, state)
+		sl.spent[state] = deadline
+	}
+	return ok
+}
+
+// replayed reports whether state was already consumed here.
+func (sl *stateLedger) replayed(state string) bool {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+
+	_, ok := sl.spent[state]
+	return ok
+}
+
+func (sl *stateLedger) overdue(now time.Time) []string {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+
+	for state, deadline := range sl.spent {
+		if now.After(deadline) {
+			delete(sl.spent, state)
+		}
+	}
+	var states []string
+	for state, deadline := range sl.pending {
+		if now.After(deadline) {
+			states = append(states, state)
+			delete(sl.pending, state)
+		}
+	}
+	return states
+}
+
+// sweepStates periodically counts the overdue states that were
+// neither consumed here nor, per their tombstones, elsewhere.
+func (s *Server) sweepStates(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case now := <-ticker.C:
+			for _, state := range s.states.overdue(now) {
+				_, err := s.store.GetDel(s.ctx, consumedStateKey(state))
+				switch {
+				case errors.Is(err, store.ErrNotFound):
+					s.metrics.expiredStates.Inc()
+				case err != nil:
+					s.logger.WarnContext(s.ctx, "sweeping state", "err", err)
+				}
+			}
+		}
+	}
+}
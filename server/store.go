@@ -0,0 +1,112 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/odeke-em/uberclick/store"
+)
+
+// deadlineStore bounds each store operation by timeout
+// in addition to any deadline of the caller's context,
+// and measures and traces each operation.
+type deadlineStore struct {
+	store.Store
+	timeout time.Duration
+	metrics *metrics
+	tracer  trace.Tracer
+}
+
+func (ds *deadlineStore) do(ctx context.Context, op string, fn func(context.Context) error) error {
+	ctx, span := ds.tracer.Start(ctx, "store."+op,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("db.operation.name", op)),
+	)
+	ctx, cancel := context.WithTimeout(ctx, ds.timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := fn(ctx)
+	ds.metrics.storeDuration.WithLabelValues(op).Observe(time.Since(start).Seconds())
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		ds.metrics.storeErrors.WithLabelValues(op).Inc()
+		endSpan(span, err)
+	} else {
+		span.End()
+	}
+	return err
+}
+
+func (ds *deadlineStore) SAdd(ctx context.Context, set string, members ...string) error {
+	return ds.do(ctx, "sadd", func(ctx context.Context) error {
+		return ds.Store.SAdd(ctx, set, members...)
+	})
+}
+
+func (ds *deadlineStore) SIsMember(ctx context.Context, set, member string) (ok bool, err error) {
+	err = ds.do(ctx, "sismember", func(ctx context.Context) (err error) {
+		ok, err = ds.Store.SIsMember(ctx, set, member)
+		return err
+	})
+	return ok, err
+}
+
+func (ds *deadlineStore) SCard(ctx context.Context, set string) (n int64, err error) {
+	err = ds.do(ctx, "scard", func(ctx context.Context) (err error) {
+		n, err = ds.Store.SCard(ctx, set)
+		return err
+	})
+	return n, err
+}
+
+func (ds *deadlineStore) HSet(ctx context.Context, table, key string, value []byte) error {
+	return ds.do(ctx, "hset", func(ctx context.Context) error {
+		return ds.Store.HSet(ctx, table, key, value)
+	})
+}
+
+func (ds *deadlineStore) HGet(ctx context.Context, table, key string) (blob []byte, err error) {
+	err = ds.do(ctx, "hget", func(ctx context.Context) (err error) {
+		blob, err = ds.Store.HGet(ctx, table, key)
+		return err
+	})
+	return blob, err
+}
+
+func (ds *deadlineStore) HPop(ctx context.Context, table, key string) (blob []byte, err error) {
+	err = ds.do(ctx, "hpop", func(ctx context.Context) (err error) {
+		blob, err = ds.Store.HPop(ctx, table, key)
+		return err
+	})
+	return blob, err
+}
+
+func (ds *deadlineStore) LPush(ctx context.Context, list string, values ...[]byte) error {
+	return ds.do(ctx, "lpush", func(ctx context.Context) error {
+		return ds.Store.LPush(ctx, list, values...)
+	})
+}
+
+func (ds *deadlineStore) Expire(ctx context.Context, key string, ttl time.Duration) error {
+	return ds.do(ctx, "expire", func(ctx context.Context) error {
+		return ds.Store.Expire(ctx, key, ttl)
+	})
+}
+
+func (ds *deadlineStore) SetEx(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return ds.do(ctx, "setex", func(ctx context.Context) error {
+		return ds.Store.SetEx(ctx, key, value, ttl)
+	})
+}
+
+func (ds *deadlineStore) GetDel(ctx context.Context, key string) (blob []byte, err error) {
+	err = ds.do(ctx, "getdel", func(ctx context.Context) (err error) {
+		blob, err = ds.Store.GetDel(ctx, key)
+		return err
+	})
+	return blob, err
+}
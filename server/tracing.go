@@ -0,0 +1,66 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/odeke-em/uberclick/server"
+
+// traced starts a span for the request served by fn under route,
+// continuing any W3C trace context sent along by the widget.
+func (s *Server) traced(route string, fn http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		ctx := s.propagator.Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+		ctx, span := s.tracer.Start(ctx, route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", req.Method),
+				attribute.String("http.route", route),
+			),
+		)
+		defer span.End()
+
+		rec := &statusRecorder{ResponseWriter: rw, status: http.StatusOK}
+		fn(rec, req.WithContext(ctx))
+		span.SetAttributes(attribute.Int("http.response.status_code", rec.status))
+		if rec.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(rec.status))
+		}
+	}
+}
+
+// startSpan starts an internal span as a child of the span of req
+// and returns req with its context updated to carry the new span.
+func (s *Server) startSpan(req *http.Request, name string) (*http.Request, trace.Span) {
+	ctx, span := s.tracer.Start(req.Context(), name)
+	return req.WithContext(ctx), span
+}
+
+// startUpstream starts a span for the Uber API operation op. The
+// returned function ends it and records the call in the metrics.
+func (s *Server) startUpstream(ctx context.Context, op string) func(error) {
+	_, span := s.tracer.Start(ctx, "uber."+op, trace.WithSpanKind(trace.SpanKindClient))
+	start := time.Now()
+	return func(err error) {
+		if err == nil {
+			s.health.lastUpstreamOK.Store(time.Now().UnixNano())
+		}
+		s.metrics.observeUpstream(op, start, err)
+		endSpan(span, err)
+	}
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,81 @@
+package server_test
+
+import (
+	"net/http"
+	"testing"
+
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/odeke-em/uberclick/server"
+	"github.com/odeke-em/uberclick/tracing"
+)
+
+// TestSpanTree checks that the spans of a request continue the trace
+// of the widget and nest the checks, store operations and upstream
+// calls under the span of the route that made them.
+func TestSpanTree(t *testing.T) {
+	tp, exp := tracing.NewInMemory()
+	h := newHarness(t, func(opts *server.Options) { opts.TracerProvider = tp })
+	apiKey := h.registerAPIKey()
+	h.authorize()
+	exp.Reset()
+
+	const (
+		widgetTraceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+		widgetSpanID  = "00f067aa0ba902b7"
+	)
+	req := h.request(http.MethodPost, "/profile", map[string]string{"api_key": apiKey, "origin": testOrigin})
+	req.Header.Set("traceparent", "00-"+widgetTraceID+"-"+widgetSpanID+"-01")
+	res, blob := h.send(req)
+	h.decode(res, blob, http.StatusOK, nil)
+
+	spans := make(map[string]tracetest.SpanStub)
+	for _, span := range exp.GetSpans() {
+		if _, ok := spans[span.Name]; !ok {
+			spans[span.Name] = span
+		}
+	}
+	find := func(name string) tracetest.SpanStub {
+		t.Helper()
+		span, ok := spans[name]
+		if !ok {
+			t.Fatalf("no %q span among %v", name, tracing.SpanNames(exp))
+		}
+		return span
+	}
+
+	route := find("/profile")
+	traceID, _ := trace.TraceIDFromHex(widgetTraceID)
+	spanID, _ := trace.SpanIDFromHex(widgetSpanID)
+	if route.SpanContext.TraceID() != traceID || route.Parent.SpanID() != spanID {
+		t.Errorf("route span is in trace %s under %s, want %s under %s",
+			route.SpanContext.TraceID(), route.Parent.SpanID(), traceID, spanID)
+	}
+	if route.SpanKind != trace.SpanKindServer {
+		t.Errorf("route span kind = %v, want server", route.SpanKind)
+	}
+
+	tree := []struct {
+		child, parent string
+		kind          trace.SpanKind
+	}{
+		{"withAPIAuthdDomains", "/profile", trace.SpanKindInternal},
+		{"store.sismember", "withAPIAuthdDomains", trace.SpanKindClient},
+		{"withAuthToken", "/profile", trace.SpanKindInternal},
+		{"store.hget", "withAuthToken", trace.SpanKindClient},
+		{"uber.profile", "/profile", trace.SpanKindClient},
+	}
+	for _, tt := range tree {
+		child, parent := find(tt.child), find(tt.parent)
+		if child.Parent.SpanID() != parent.SpanContext.SpanID() {
+			t.Errorf("%s is a child of span %s, want %s (%s)", tt.child, child.Parent.SpanID(), tt.parent, parent.SpanContext.SpanID())
+		}
+		if child.SpanContext.TraceID() != traceID {
+			t.Errorf("%s is in trace %s, want %s", tt.child, child.SpanContext.TraceID(), traceID)
+		}
+		if child.SpanKind != tt.kind {
+			t.Errorf("%s kind = %v, want %v", tt.child, child.SpanKind, tt.kind)
+		}
+	}
+}
@@ -0,0 +1,394 @@
+// Package filestore implements store.Store in a single local file, for
+// single-node deployments that would rather not operate Redis.
+//
+// The contents are held in memory and every mutation is appended to the
+// file as a JSON journal entry. On open the journal is replayed, and it
+// is periodically compacted into a single snapshot entry. Keys with a
+// TTL are deleted lazily on access and by a background sweeper.
+package filestore
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/odeke-em/uberclick/store"
+	"github.com/odeke-em/uberclick/store/memstore"
+)
+
+type Options struct {
+	// SweepInterval is how often expired keys are deleted.
+	// It defaults to one minute.
+	SweepInterval time.Duration
+
+	// CompactAfter is the number of journal entries
+	// after which the file is compacted. It defaults to 10000.
+	CompactAfter int
+
+	// Sync if set fsyncs the file after every mutation.
+	Sync bool
+}
+
+type Store struct {
+	mem  *memstore.Store
+	path string
+	opts Options
+
+	mu      sync.Mutex
+	f       *os.File
+	w       *bufio.Writer
+	entries int
+	closed  bool
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+var _ store.Store = (*Store)(nil)
+
+type entry struct {
+	Op       string             `json:"op"`
+	Key      string             `json:"k,omitempty"`
+	Field    string             `json:"f,omitempty"`
+	Members  []string           `json:"m,omitempty"`
+	Values   [][]byte           `json:"v,omitempty"`
+	Deadline *time.Time         `json:"d,omitempty"`
+	Snapshot *memstore.Snapshot `json:"s,omitempty"`
+}
+
+const (
+	opSnapshot = "snapshot"
+	opSAdd     = "sadd"
+	opHSet     = "hset"
+	opHDel     = "hdel"
+	opLPush    = "lpush"
+	opSetEx    = "setex"
+	opDel      = "del"
+	opExpire   = "expire"
+)
+
+// Open loads, or creates, the store in the file at path.
+func Open(path string, opts *Options) (*Store, error) {
+	s := &Store{
+		mem:  memstore.New(),
+		path: path,
+		opts: Options{SweepInterval: time.Minute, CompactAfter: 10000},
+		done: make(chan struct{}),
+	}
+	if opts != nil {
+		s.opts.Sync = opts.Sync
+		if opts.SweepInterval > 0 {
+			s.opts.SweepInterval = opts.SweepInterval
+		}
+		if opts.CompactAfter > 0 {
+			s.opts.CompactAfter = opts.CompactAfter
+		}
+	}
+
+	if err := s.replay(); err != nil {
+		return nil, err
+	}
+	// Compacting on open also drops any torn final entry.
+	if err := s.compactLocked(); err != nil {
+		return nil, err
+	}
+
+	s.wg.Add(1)
+	go s.sweep()
+	return s, nil
+}
+
+// replay applies the journal in the file, of which only the last line
+// may be torn. Any other entry that cannot be decoded is reported as an
+// error rather than dropped, along with everything after it, by the
+// compaction that follows.
+func (s *Store) replay() error {
+	f, err := os.Open(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	ctx := context.Background()
+	r := bufio.NewReader(f)
+	for line := 1; ; line++ {
+		blob, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			// A torn write at the tail is the expected outcome
+			// of a crash so it is dropped if incomplete.
+			if len(blob) > 0 {
+				if e := new(entry); json.Unmarshal(blob, e) == nil {
+					return s.apply(ctx, e)
+				}
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		e := new(entry)
+		if err := json.Unmarshal(blob, e); err != nil {
+			return fmt.Errorf("filestore: %q: corrupt journal entry on line %d: %v", s.path, line, err)
+		}
+		if err := s.apply(ctx, e); err != nil {
+			return err
+		}
+	}
+}
+
+// apply replays the journal entry e onto the in-memory state.
+func (s *Store) apply(ctx context.Context, e *entry) (err error) {
+	switch e.Op {
+	case opSnapshot:
+		if e.Snapshot != nil {
+			s.mem.Restore(e.Snapshot)
+		}
+	case opSAdd:
+		err = s.mem.SAdd(ctx, e.Key, e.Members...)
+	case opHSet:
+		if len(e.Values) == 1 {
+			err = s.mem.HSet(ctx, e.Key, e.Field, e.Values[0])
+		}
+	case opHDel:
+		_, err = s.mem.HPop(ctx, e.Key, e.Field)
+	case opLPush:
+		err = s.mem.LPush(ctx, e.Key, e.Values...)
+	case opSetEx:
+		if len(e.Values) == 1 && e.Deadline != nil {
+			err = s.mem.SetAt(ctx, e.Key, e.Values[0], *e.Deadline)
+		}
+	case opDel:
+		_, err = s.mem.GetDel(ctx, e.Key)
+	case opExpire:
+		if e.Deadline != nil {
+			err = s.mem.ExpireAt(ctx, e.Key, *e.Deadline)
+		}
+	default:
+		return fmt.Errorf("filestore: %q: unknown journal op %q", s.path, e.Op)
+	}
+	if err == store.ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+// compactLocked atomically replaces the file with a single
+// snapshot entry and reopens it for appending.
+func (s *Store) compactLocked() error {
+	if s.f != nil {
+		if err := s.w.Flush(); err != nil {
+			return err
+		}
+	}
+
+	s.mem.Sweep()
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".compact-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := json.NewEncoder(tmp).Encode(&entry{Op: opSnapshot, Snapshot: s.mem.Snapshot()}); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if s.f != nil {
+		s.f.Close()
+	}
+	s.f = f
+	s.w = bufio.NewWriter(f)
+	s.entries = 0
+	return nil
+}
+
+// mutate journals e and then applies fn to the in-memory state,
+// serializing both so that the journal order matches. Should the
+// journal not be written, the state is left as it was. Entries
+// whose fn finds nothing to change replay as no-ops.
+func (s *Store) mutate(ctx context.Context, e *entry, fn func(context.Context) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return store.ErrClosed
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := s.journalLocked(e); err != nil {
+		return err
+	}
+	// Once journaled, e must be applied even if ctx ends meanwhile.
+	if err := fn(context.WithoutCancel(ctx)); err != nil {
+		return err
+	}
+	if s.entries++; s.entries >= s.opts.CompactAfter {
+		return s.compactLocked()
+	}
+	return nil
+}
+
+func (s *Store) journalLocked(e *entry) error {
+	blob, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if _, err = s.w.Write(append(blob, '\n')); err == nil {
+		err = s.w.Flush()
+	}
+	if err == nil && s.opts.Sync {
+		err = s.f.Sync()
+	}
+	if err != nil {
+		// Part of e may have reached the file, so it is rewritten
+		// from memory lest the torn entry be followed by others.
+		s.w.Reset(s.f)
+		if cerr := s.compactLocked(); cerr != nil {
+			return errors.Join(err, cerr)
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *Store) sweep() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.opts.SweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			// Deletions need not be journaled since
+			// the expiries are replayed on open.
+			s.mem.Sweep()
+		}
+	}
+}
+
+func (s *Store) SAdd(ctx context.Context, set string, members ...string) error {
+	return s.mutate(ctx, &entry{Op: opSAdd, Key: set, Members: members}, func(ctx context.Context) error {
+		return s.mem.SAdd(ctx, set, members...)
+	})
+}
+
+func (s *Store) SIsMember(ctx context.Context, set, member string) (bool, error) {
+	if err := s.checkOpen(); err != nil {
+		return false, err
+	}
+	return s.mem.SIsMember(ctx, set, member)
+}
+
+func (s *Store) SCard(ctx context.Context, set string) (int64, error) {
+	if err := s.checkOpen(); err != nil {
+		return 0, err
+	}
+	return s.mem.SCard(ctx, set)
+}
+
+func (s *Store) HSet(ctx context.Context, table, key string, value []byte) error {
+	return s.mutate(ctx, &entry{Op: opHSet, Key: table, Field: key, Values: [][]byte{value}}, func(ctx context.Context) error {
+		return s.mem.HSet(ctx, table, key, value)
+	})
+}
+
+func (s *Store) HGet(ctx context.Context, table, key string) ([]byte, error) {
+	if err := s.checkOpen(); err != nil {
+		return nil, err
+	}
+	return s.mem.HGet(ctx, table, key)
+}
+
+func (s *Store) HPop(ctx context.Context, table, key string) (blob []byte, err error) {
+	err = s.mutate(ctx, &entry{Op: opHDel, Key: table, Field: key}, func(ctx context.Context) (err error) {
+		blob, err = s.mem.HPop(ctx, table, key)
+		return err
+	})
+	return blob, err
+}
+
+func (s *Store) LPush(ctx context.Context, list string, values ...[]byte) error {
+	return s.mutate(ctx, &entry{Op: opLPush, Key: list, Values: values}, func(ctx context.Context) error {
+		return s.mem.LPush(ctx, list, values...)
+	})
+}
+
+func (s *Store) SetEx(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	deadline := time.Now().Add(ttl)
+	return s.mutate(ctx, &entry{Op: opSetEx, Key: key, Values: [][]byte{value}, Deadline: &deadline}, func(ctx context.Context) error {
+		return s.mem.SetAt(ctx, key, value, deadline)
+	})
+}
+
+func (s *Store) GetDel(ctx context.Context, key string) (blob []byte, err error) {
+	err = s.mutate(ctx, &entry{Op: opDel, Key: key}, func(ctx context.Context) (err error) {
+		blob, err = s.mem.GetDel(ctx, key)
+		return err
+	})
+	return blob, err
+}
+
+func (s *Store) Expire(ctx context.Context, key string, ttl time.Duration) error {
+	// The deadline is journaled rather than the TTL
+	// so that replaying does not extend its lifetime.
+	deadline := time.Now().Add(ttl)
+	return s.mutate(ctx, &entry{Op: opExpire, Key: key, Deadline: &deadline}, func(ctx context.Context) error {
+		return s.mem.ExpireAt(ctx, key, deadline)
+	})
+}
+
+func (s *Store) checkOpen() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return store.ErrClosed
+	}
+	return nil
+}
+
+func (s *Store) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return store.ErrClosed
+	}
+	s.closed = true
+	close(s.done)
+	err := s.compactLocked()
+	if cerr := s.f.Close(); err == nil {
+		err = cerr
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return err
+}
@@ -0,0 +1,114 @@
+package filestore_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/odeke-em/uberclick/store"
+	"github.com/odeke-em/uberclick/store/filestore"
+	"github.com/odeke-em/uberclick/store/storetest"
+)
+
+func TestConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.Store {
+		st, err := filestore.Open(filepath.Join(t.TempDir(), "db"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return st
+	})
+}
+
+// journal writes lines as the file at a fresh path and returns it.
+func journal(t *testing.T, lines ...string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "db")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "")), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestOpenDropsTornFinalEntry(t *testing.T) {
+	path := journal(t,
+		`{"op":"sadd","k":"set","m":["a"]}`+"\n",
+		`{"op":"sadd","k":"set","m":["b"]}`+"\n",
+		`{"op":"sadd","k":"se`,
+	)
+	st, err := filestore.Open(path, nil)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer st.Close()
+
+	ctx := context.Background()
+	for _, member := range []string{"a", "b"} {
+		if ok, err := st.SIsMember(ctx, "set", member); err != nil || !ok {
+			t.Errorf("SIsMember(%q) = (%v, %v), want (true, nil)", member, ok, err)
+		}
+	}
+}
+
+func TestOpenRejectsCorruptEntry(t *testing.T) {
+	lines := []string{
+		`{"op":"sadd","k":"set","m":["a"]}` + "\n",
+		`{"op":"sadd","k":"se` + "\n",
+		`{"op":"sadd","k":"set","m":["b"]}` + "\n",
+	}
+	path := journal(t, lines...)
+	if st, err := filestore.Open(path, nil); err == nil {
+		st.Close()
+		t.Fatal("Open of a journal corrupted midway succeeded")
+	} else if !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("Open = %v, want it to name line 2", err)
+	}
+
+	// Nothing after the corruption may have been compacted away.
+	blob, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(blob) != strings.Join(lines, "") {
+		t.Errorf("Open rewrote the corrupt journal to %q", blob)
+	}
+}
+
+func TestReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	ctx := context.Background()
+
+	st, err := filestore.Open(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := st.HSet(ctx, "table", "key", []byte("value")); err != nil {
+		t.Fatalf("HSet: %v", err)
+	}
+	if _, err := st.HPop(ctx, "table", "missing"); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("HPop of a missing field = %v, want %v", err, store.ErrNotFound)
+	}
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := st.SAdd(cancelled, "set", "a"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("SAdd with a cancelled context = %v, want %v", err, context.Canceled)
+	}
+	if err := st.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	st, err = filestore.Open(path, nil)
+	if err != nil {
+		t.Fatalf("reopening: %v", err)
+	}
+	defer st.Close()
+	if blob, err := st.HGet(ctx, "table", "key"); err != nil || string(blob) != "value" {
+		t.Errorf("HGet after reopening = (%q, %v), want (%q, nil)", blob, err, "value")
+	}
+	if ok, err := st.SIsMember(ctx, "set", "a"); err != nil || ok {
+		t.Errorf("SIsMember of a cancelled SAdd = (%v, %v), want (false, nil)", ok, err)
+	}
+}
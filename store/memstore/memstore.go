@@ -0,0 +1,324 @@
+// Package memstore implements store.Store in memory.
+// It is intended for tests and local development and
+// is the in-memory state behind the filestore backend.
+package memstore
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/odeke-em/uberclick/store"
+)
+
+type Store struct {
+	mu       sync.Mutex
+	closed   bool
+	now      func() time.Time
+	sets     map[string]map[string]bool
+	hashes   map[string]map[string][]byte
+	lists    map[string][][]byte
+	strs     map[string][]byte
+	expiries map[string]time.Time
+}
+
+var _ store.Store = (*Store)(nil)
+
+func New() *Store {
+	return &Store{
+		now:      time.Now,
+		sets:     make(map[string]map[string]bool),
+		hashes:   make(map[string]map[string][]byte),
+		lists:    make(map[string][][]byte),
+		strs:     make(map[string][]byte),
+		expiries: make(map[string]time.Time),
+	}
+}
+
+// SetClock replaces the clock used to expire keys.
+func (s *Store) SetClock(now func() time.Time) {
+	s.mu.Lock()
+	s.now = now
+	s.mu.Unlock()
+}
+
+// locked runs fn while holding the lock, provided
+// the store is open and ctx is not yet done.
+func (s *Store) locked(ctx context.Context, fn func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return store.ErrClosed
+	}
+	return fn()
+}
+
+// expireLocked lazily deletes key if its TTL has elapsed.
+func (s *Store) expireLocked(key string) {
+	if deadline, ok := s.expiries[key]; ok && !s.now().Before(deadline) {
+		s.deleteLocked(key)
+	}
+}
+
+func (s *Store) deleteLocked(key string) {
+	delete(s.sets, key)
+	delete(s.hashes, key)
+	delete(s.lists, key)
+	delete(s.strs, key)
+	delete(s.expiries, key)
+}
+
+func (s *Store) existsLocked(key string) bool {
+	if _, ok := s.strs[key]; ok {
+		return true
+	}
+	return len(s.sets[key]) > 0 || len(s.hashes[key]) > 0 || len(s.lists[key]) > 0
+}
+
+func (s *Store) SAdd(ctx context.Context, set string, members ...string) error {
+	return s.locked(ctx, func() error {
+		s.expireLocked(set)
+		m := s.sets[set]
+		if m == nil {
+			m = make(map[string]bool)
+			s.sets[set] = m
+		}
+		for _, member := range members {
+			m[member] = true
+		}
+		return nil
+	})
+}
+
+func (s *Store) SIsMember(ctx context.Context, set, member string) (ok bool, err error) {
+	err = s.locked(ctx, func() error {
+		s.expireLocked(set)
+		ok = s.sets[set][member]
+		return nil
+	})
+	return ok, err
+}
+
+func (s *Store) SCard(ctx context.Context, set string) (n int64, err error) {
+	err = s.locked(ctx, func() error {
+		s.expireLocked(set)
+		n = int64(len(s.sets[set]))
+		return nil
+	})
+	return n, err
+}
+
+func (s *Store) HSet(ctx context.Context, table, key string, value []byte) error {
+	return s.locked(ctx, func() error {
+		s.expireLocked(table)
+		h := s.hashes[table]
+		if h == nil {
+			h = make(map[string][]byte)
+			s.hashes[table] = h
+		}
+		h[key] = append([]byte(nil), value...)
+		return nil
+	})
+}
+
+func (s *Store) HGet(ctx context.Context, table, key string) (blob []byte, err error) {
+	err = s.locked(ctx, func() error {
+		s.expireLocked(table)
+		v, ok := s.hashes[table][key]
+		if !ok {
+			return store.ErrNotFound
+		}
+		blob = append([]byte(nil), v...)
+		return nil
+	})
+	return blob, err
+}
+
+func (s *Store) HPop(ctx context.Context, table, key string) (blob []byte, err error) {
+	err = s.locked(ctx, func() error {
+		s.expireLocked(table)
+		v, ok := s.hashes[table][key]
+		if !ok {
+			return store.ErrNotFound
+		}
+		delete(s.hashes[table], key)
+		if !s.existsLocked(table) {
+			s.deleteLocked(table)
+		}
+		blob = v
+		return nil
+	})
+	return blob, err
+}
+
+func (s *Store) LPush(ctx context.Context, list string, values ...[]byte) error {
+	return s.locked(ctx, func() error {
+		s.expireLocked(list)
+		for _, value := range values {
+			s.lists[list] = append([][]byte{append([]byte(nil), value...)}, s.lists[list]...)
+		}
+		return nil
+	})
+}
+
+func (s *Store) SetEx(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return s.locked(ctx, func() error {
+		s.setLocked(key, value, s.now().Add(ttl))
+		return nil
+	})
+}
+
+// SetAt is like SetEx but takes an absolute deadline.
+func (s *Store) SetAt(ctx context.Context, key string, value []byte, deadline time.Time) error {
+	return s.locked(ctx, func() error {
+		s.setLocked(key, value, deadline)
+		return nil
+	})
+}
+
+func (s *Store) setLocked(key string, value []byte, deadline time.Time) {
+	s.deleteLocked(key)
+	s.strs[key] = append([]byte(nil), value...)
+	s.expiries[key] = deadline
+	s.expireLocked(key)
+}
+
+func (s *Store) GetDel(ctx context.Context, key string) (blob []byte, err error) {
+	err = s.locked(ctx, func() error {
+		s.expireLocked(key)
+		v, ok := s.strs[key]
+		if !ok {
+			return store.ErrNotFound
+		}
+		s.deleteLocked(key)
+		blob = v
+		return nil
+	})
+	return blob, err
+}
+
+func (s *Store) Expire(ctx context.Context, key string, ttl time.Duration) error {
+	return s.locked(ctx, func() error {
+		return s.expireAtLocked(key, s.now().Add(ttl))
+	})
+}
+
+// ExpireAt is like Expire but takes an absolute deadline.
+func (s *Store) ExpireAt(ctx context.Context, key string, deadline time.Time) error {
+	return s.locked(ctx, func() error {
+		return s.expireAtLocked(key, deadline)
+	})
+}
+
+func (s *Store) expireAtLocked(key string, deadline time.Time) error {
+	s.expireLocked(key)
+	if !s.existsLocked(key) {
+		return store.ErrNotFound
+	}
+	s.expiries[key] = deadline
+	s.expireLocked(key)
+	return nil
+}
+
+// Sweep deletes every key whose TTL has elapsed
+// and returns the keys that it deleted.
+func (s *Store) Sweep() (swept []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	for key, deadline := range s.expiries {
+		if !now.Before(deadline) {
+			s.deleteLocked(key)
+			swept = append(swept, key)
+		}
+	}
+	return swept
+}
+
+func (s *Store) Close() error {
+	return s.locked(context.Background(), func() error {
+		s.closed = true
+		return nil
+	})
+}
+
+// Snapshot is a point in time copy of a Store's contents.
+type Snapshot struct {
+	Sets     map[string][]string          `json:"sets,omitempty"`
+	Hashes   map[string]map[string][]byte `json:"hashes,omitempty"`
+	Lists    map[string][][]byte          `json:"lists,omitempty"`
+	Strings  map[string][]byte            `json:"strings,omitempty"`
+	Expiries map[string]time.Time         `json:"expiries,omitempty"`
+}
+
+func (s *Store) Snapshot() *Snapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snap := &Snapshot{
+		Sets:     make(map[string][]string),
+		Hashes:   make(map[string]map[string][]byte),
+		Lists:    make(map[string][][]byte),
+		Strings:  make(map[string][]byte),
+		Expiries: make(map[string]time.Time),
+	}
+	for name, members := range s.sets {
+		for member := range members {
+			snap.Sets[name] = append(snap.Sets[name], member)
+		}
+	}
+	for name, h := range s.hashes {
+		snap.Hashes[name] = make(map[string][]byte)
+		for key, value := range h {
+			snap.Hashes[name][key] = value
+		}
+	}
+	for name, list := range s.lists {
+		snap.Lists[name] = append([][]byte(nil), list...)
+	}
+	for key, value := range s.strs {
+		snap.Strings[key] = value
+	}
+	for key, deadline := range s.expiries {
+		snap.Expiries[key] = deadline
+	}
+	return snap
+}
+
+// Restore replaces the contents of the Store with snap.
+func (s *Store) Restore(snap *Snapshot) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sets = make(map[string]map[string]bool)
+	s.hashes = make(map[string]map[string][]byte)
+	s.lists = make(map[string][][]byte)
+	s.strs = make(map[string][]byte)
+	s.expiries = make(map[string]time.Time)
+	for name, members := range snap.Sets {
+		s.sets[name] = make(map[string]bool)
+		for _, member := range members {
+			s.sets[name][member] = true
+		}
+	}
+	for name, h := range snap.Hashes {
+		s.hashes[name] = make(map[string][]byte)
+		for key, value := range h {
+			s.hashes[name][key] = value
+		}
+	}
+	for name, list := range snap.Lists {
+		s.lists[name] = append([][]byte(nil), list...)
+	}
+	for key, value := range snap.Strings {
+		s.strs[key] = value
+	}
+	for key, deadline := range snap.Expiries {
+		s.expiries[key] = deadline
+	}
+}
@@ -0,0 +1,15 @@
+package memstore_test
+
+import (
+	"testing"
+
+	"github.com/odeke-em/uberclick/store"
+	"github.com/odeke-em/uberclick/store/memstore"
+	"github.com/odeke-em/uberclick/store/storetest"
+)
+
+func TestConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.Store {
+		return memstore.New()
+	})
+}
@@ -0,0 +1,325 @@
+package store
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Dialer connects to a backing server and returns a Store for it.
+type Dialer func(ctx context.Context) (Store, error)
+
+type ReconnectOptions struct {
+	// MaxAttempts is the number of dials tried per reconnection.
+	MaxAttempts int
+
+	// BaseDelay is doubled after every failed dial up
+	// to MaxDelay, with full jitter applied to each wait.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+
+	// BreakerThreshold consecutive connection failures trip
+	// the circuit breaker for BreakerCooldown, during which
+	// calls fail fast with ErrCircuitOpen.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+}
+
+var defaultReconnectOptions = ReconnectOptions{
+	MaxAttempts:      5,
+	BaseDelay:        50 * time.Millisecond,
+	MaxDelay:         2 * time.Second,
+	BreakerThreshold: 5,
+	BreakerCooldown:  10 * time.Second,
+}
+
+// Reconnecting is a Store that transparently redials its backing server
+// whenever an operation fails with a ConnError, and then retries the
+// operation once. It is safe for concurrent use.
+type Reconnecting struct {
+	dial    Dialer
+	opts    ReconnectOptions
+	breaker *breaker
+
+	mu        sync.RWMutex
+	st        Store
+	gen       uint64
+	closed    bool
+	redialing *redial
+
+	// OnReconnect if set is invoked after every reconnection
+	// attempt with the error, if any, that it ended with.
+	OnReconnect func(err error)
+}
+
+var _ Store = (*Reconnecting)(nil)
+
+// NewReconnecting dials the initial connection and returns
+// a Store that reconnects through dial with opts, which
+// if nil or zero valued are replaced by defaults.
+func NewReconnecting(ctx context.Context, dial Dialer, opts *ReconnectOptions) (*Reconnecting, error) {
+	ropts := defaultReconnectOptions
+	if opts != nil {
+		if opts.MaxAttempts > 0 {
+			ropts.MaxAttempts = opts.MaxAttempts
+		}
+		if opts.BaseDelay > 0 {
+			ropts.BaseDelay = opts.BaseDelay
+		}
+		if opts.MaxDelay > 0 {
+			ropts.MaxDelay = opts.MaxDelay
+		}
+		if opts.BreakerThreshold > 0 {
+			ropts.BreakerThreshold = opts.BreakerThreshold
+		}
+		if opts.BreakerCooldown > 0 {
+			ropts.BreakerCooldown = opts.BreakerCooldown
+		}
+	}
+
+	st, err := dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &Reconnecting{
+		dial: dial,
+		opts: ropts,
+		st:   st,
+		breaker: &breaker{
+			threshold: ropts.BreakerThreshold,
+			cooldown:  ropts.BreakerCooldown,
+			now:       time.Now,
+		},
+	}, nil
+}
+
+func (r *Reconnecting) current() (Store, uint64, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.closed {
+		return nil, 0, ErrClosed
+	}
+	return r.st, r.gen, nil
+}
+
+// do runs fn against the current connection, reconnecting and
+// retrying fn once if it failed with a connection error.
+func (r *Reconnecting) do(ctx context.Context, fn func(Store) error) error {
+	if err := r.breaker.allow(); err != nil {
+		return err
+	}
+	st, gen, err := r.current()
+	if err != nil {
+		r.breaker.success()
+		return err
+	}
+
+	err = fn(st)
+	if !IsConnError(err) {
+		r.breaker.success()
+		return err
+	}
+
+	if st, err = r.reconnect(ctx, gen); err != nil {
+		r.fail(ctx)
+		return err
+	}
+	if err = fn(st); IsConnError(err) {
+		r.fail(ctx)
+	} else {
+		r.breaker.success()
+	}
+	return err
+}
+
+// fail records a failed call unless it failed because its caller
+// gave up, which says nothing about the health of the backing
+// server and must not trip the breaker for every other caller.
+func (r *Reconnecting) fail(ctx context.Context) {
+	if ctx.Err() != nil {
+		r.breaker.abandon()
+	} else {
+		r.breaker.failure()
+	}
+}
+
+// reconnect replaces the connection of generation gen unless another
+// caller already did so, in which case the newer connection is used.
+// Only one caller redials at a time, without holding the lock, while
+// the others wait for its outcome for as long as their own ctx allows.
+func (r *Reconnecting) reconnect(ctx context.Context, gen uint64) (Store, error) {
+	for {
+		r.mu.Lock()
+		if r.closed {
+			r.mu.Unlock()
+			return nil, ErrClosed
+		}
+		if r.gen != gen {
+			st := r.st
+			r.mu.Unlock()
+			return st, nil
+		}
+		if rd := r.redialing; rd != nil {
+			r.mu.Unlock()
+			select {
+			case <-rd.done:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			// Unless the redial gave up on the backing server,
+			// rather than on the context of its caller, either
+			// its connection or a fresh redial is to be used.
+			if IsConnError(rd.err) {
+				return nil, rd.err
+			}
+			continue
+		}
+		rd := &redial{done: make(chan struct{})}
+		r.redialing = rd
+		r.mu.Unlock()
+
+		st, err := r.redial(ctx)
+
+		r.mu.Lock()
+		var stale Store
+		switch {
+		case err != nil:
+		case r.closed:
+			stale, st, err = st, nil, ErrClosed
+		default:
+			stale, r.st = r.st, st
+			r.gen++
+		}
+		r.redialing = nil
+		rd.err = err
+		close(rd.done)
+		r.mu.Unlock()
+
+		if stale != nil {
+			stale.Close()
+		}
+		if r.OnReconnect != nil {
+			r.OnReconnect(err)
+		}
+		return st, err
+	}
+}
+
+// redial is a reconnection in progress whose
+// err is set by the time that done is closed.
+type redial struct {
+	done chan struct{}
+	err  error
+}
+
+// redial dials up to MaxAttempts times with jittered
+// exponential backoff between the failed attempts.
+func (r *Reconnecting) redial(ctx context.Context) (st Store, err error) {
+	delay := r.opts.BaseDelay
+	for i := 0; i < r.opts.MaxAttempts; i++ {
+		if i > 0 {
+			// Full jitter spreads out the redials
+			// of many instances that lost Redis together.
+			wait := time.Duration(rand.Int63n(int64(delay) + 1))
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			if delay *= 2; delay > r.opts.MaxDelay {
+				delay = r.opts.MaxDelay
+			}
+		}
+
+		if st, err = r.dial(ctx); err == nil {
+			return st, nil
+		}
+	}
+	if IsConnError(err) {
+		return nil, err
+	}
+	return nil, &ConnError{Err: err}
+}
+
+func (r *Reconnecting) SAdd(ctx context.Context, set string, members ...string) error {
+	return r.do(ctx, func(st Store) error {
+		return st.SAdd(ctx, set, members...)
+	})
+}
+
+func (r *Reconnecting) SIsMember(ctx context.Context, set, member string) (ok bool, err error) {
+	err = r.do(ctx, func(st Store) (err error) {
+		ok, err = st.SIsMember(ctx, set, member)
+		return err
+	})
+	return ok, err
+}
+
+func (r *Reconnecting) SCard(ctx context.Context, set string) (n int64, err error) {
+	err = r.do(ctx, func(st Store) (err error) {
+		n, err = st.SCard(ctx, set)
+		return err
+	})
+	return n, err
+}
+
+func (r *Reconnecting) HSet(ctx context.Context, table, key string, value []byte) error {
+	return r.do(ctx, func(st Store) error {
+		return st.HSet(ctx, table, key, value)
+	})
+}
+
+func (r *Reconnecting) HGet(ctx context.Context, table, key string) (blob []byte, err error) {
+	err = r.do(ctx, func(st Store) (err error) {
+		blob, err = st.HGet(ctx, table, key)
+		return err
+	})
+	return blob, err
+}
+
+func (r *Reconnecting) HPop(ctx context.Context, table, key string) (blob []byte, err error) {
+	err = r.do(ctx, func(st Store) (err error) {
+		blob, err = st.HPop(ctx, table, key)
+		return err
+	})
+	return blob, err
+}
+
+func (r *Reconnecting) LPush(ctx context.Context, list string, values ...[]byte) error {
+	return r.do(ctx, func(st Store) error {
+		return st.LPush(ctx, list, values...)
+	})
+}
+
+func (r *Reconnecting) SetEx(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return r.do(ctx, func(st Store) error {
+		return st.SetEx(ctx, key, value, ttl)
+	})
+}
+
+func (r *Reconnecting) GetDel(ctx context.Context, key string) (blob []byte, err error) {
+	err = r.do(ctx, func(st Store) (err error) {
+		blob, err = st.GetDel(ctx, key)
+		return err
+	})
+	return blob, err
+}
+
+func (r *Reconnecting) Expire(ctx context.Context, key string, ttl time.Duration) error {
+	return r.do(ctx, func(st Store) error {
+		return st.Expire(ctx, key, ttl)
+	})
+}
+
+func (r *Reconnecting) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return ErrClosed
+	}
+	r.closed = true
+	return r.st.Close()
+}
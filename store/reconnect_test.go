@@ -0,0 +1,179 @@
+package store_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/odeke-em/uberclick/store"
+	"github.com/odeke-em/uberclick/store/memstore"
+)
+
+var errBrokenPipe = errors.New("broken pipe")
+
+// brokenStore fails every SAdd as if its connection had dropped.
+type brokenStore struct {
+	store.Store
+}
+
+func (bs *brokenStore) SAdd(ctx context.Context, set string, members ...string) error {
+	return &store.ConnError{Err: errBrokenPipe}
+}
+
+var fastReconnects = &store.ReconnectOptions{
+	MaxAttempts:      3,
+	BaseDelay:        time.Millisecond,
+	MaxDelay:         time.Millisecond,
+	BreakerThreshold: 100,
+}
+
+func TestReconnect(t *testing.T) {
+	var dials atomic.Int32
+	dial := func(ctx context.Context) (store.Store, error) {
+		if dials.Add(1) == 1 {
+			return &brokenStore{Store: memstore.New()}, nil
+		}
+		return memstore.New(), nil
+	}
+	var reconnects []error
+	r, err := store.NewReconnecting(context.Background(), dial, fastReconnects)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	r.OnReconnect = func(err error) { reconnects = append(reconnects, err) }
+
+	if err := r.SAdd(context.Background(), "set", "a"); err != nil {
+		t.Fatalf("SAdd after reconnecting: %v", err)
+	}
+	if ok, err := r.SIsMember(context.Background(), "set", "a"); err != nil || !ok {
+		t.Fatalf("SIsMember = (%v, %v), want (true, nil)", ok, err)
+	}
+	if got := dials.Load(); got != 2 {
+		t.Errorf("dials = %d, want 2", got)
+	}
+	if len(reconnects) != 1 || reconnects[0] != nil {
+		t.Errorf("OnReconnect got %v, want a single successful reconnection", reconnects)
+	}
+}
+
+func TestReconnectGivesUp(t *testing.T) {
+	var dials atomic.Int32
+	dial := func(ctx context.Context) (store.Store, error) {
+		if dials.Add(1) == 1 {
+			return &brokenStore{Store: memstore.New()}, nil
+		}
+		// Dialers may report connection errors themselves.
+		return nil, &store.ConnError{Err: errBrokenPipe}
+	}
+	r, err := store.NewReconnecting(context.Background(), dial, fastReconnects)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	err = r.SAdd(context.Background(), "set", "a")
+	if !store.IsConnError(err) || !errors.Is(err, errBrokenPipe) {
+		t.Fatalf("SAdd = %v, want a connection error wrapping %v", err, errBrokenPipe)
+	}
+	if n := strings.Count(err.Error(), "connection error"); n != 1 {
+		t.Errorf("error %q mentions the connection error %d times, want once", err, n)
+	}
+	if got, want := dials.Load(), int32(1+fastReconnects.MaxAttempts); got != want {
+		t.Errorf("dials = %d, want %d", got, want)
+	}
+}
+
+// TestReconnectDoesNotBlock checks that a slow redial holds up
+// neither Close nor callers whose contexts end in the meantime.
+func TestReconnectDoesNotBlock(t *testing.T) {
+	release := make(chan struct{})
+	var dials atomic.Int32
+	dial := func(ctx context.Context) (store.Store, error) {
+		if dials.Add(1) == 1 {
+			return &brokenStore{Store: memstore.New()}, nil
+		}
+		<-release
+		return memstore.New(), nil
+	}
+	r, err := store.NewReconnecting(context.Background(), dial, fastReconnects)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	redialed := make(chan error, 1)
+	go func() { redialed <- r.SAdd(context.Background(), "set", "a") }()
+	for dials.Load() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := r.SAdd(ctx, "set", "b"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("SAdd while redialing = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	closed := make(chan error, 1)
+	go func() { closed <- r.Close() }()
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Errorf("Close: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Close blocked on the redial")
+	}
+
+	close(release)
+	if err := <-redialed; !errors.Is(err, store.ErrClosed) {
+		t.Errorf("SAdd redialing across Close = %v, want %v", err, store.ErrClosed)
+	}
+	if got := dials.Load(); got != 2 {
+		t.Errorf("dials = %d, want 2 as waiters share the redial", got)
+	}
+}
+
+// TestReconnectCancelledKeepsBreakerClosed checks that callers
+// whose contexts end while redialing do not trip the breaker.
+func TestReconnectCancelledKeepsBreakerClosed(t *testing.T) {
+	var healthy atomic.Bool
+	var dials atomic.Int32
+	dial := func(ctx context.Context) (store.Store, error) {
+		if dials.Add(1) == 1 {
+			return &brokenStore{Store: memstore.New()}, nil
+		}
+		if healthy.Load() {
+			return memstore.New(), nil
+		}
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	r, err := store.NewReconnecting(context.Background(), dial, &store.ReconnectOptions{
+		MaxAttempts:      3,
+		BaseDelay:        time.Millisecond,
+		MaxDelay:         time.Millisecond,
+		BreakerThreshold: 1,
+		BreakerCooldown:  time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	for i := 0; i < 3; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+		err := r.SAdd(ctx, "set", "a")
+		cancel()
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("SAdd %d = %v, want %v", i, err, context.DeadlineExceeded)
+		}
+	}
+
+	healthy.Store(true)
+	if err := r.SAdd(context.Background(), "set", "a"); err != nil {
+		t.Fatalf("SAdd after timed out callers = %v, want the breaker to have stayed closed", err)
+	}
+}
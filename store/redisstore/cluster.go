@@ -0,0 +1,426 @@
+package redisstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/odeke-em/uberclick/store"
+)
+
+const numSlots = 16384
+
+var errNoSeeds = errors.New("redisstore: expecting at least one cluster seed address")
+
+// Cluster is a store.Store over a Redis Cluster. Every operation is
+// routed to the node serving the hash slot of its key, honoring
+// {hash tags}. The slot map is refreshed whenever a node replies
+// with MOVED or its connection breaks, while ASK replies are
+// followed for just the command at hand.
+type Cluster struct {
+	seeds   []string
+	nodeURL url.URL
+
+	mu     sync.RWMutex
+	slots  [numSlots]string
+	nodes  map[string]*Client
+	closed bool
+}
+
+var _ store.Store = (*Cluster)(nil)
+
+// NewCluster discovers the cluster's slot map from the first reachable
+// of seeds. nodeURL supplies the scheme and credentials used to connect
+// to each node and may be nil.
+func NewCluster(ctx context.Context, seeds []string, nodeURL *url.URL) (*Cluster, error) {
+	if len(seeds) == 0 {
+		return nil, errNoSeeds
+	}
+	c := &Cluster{
+		seeds:   seeds,
+		nodeURL: url.URL{Scheme: "redis"},
+		nodes:   make(map[string]*Client),
+	}
+	if nodeURL != nil {
+		c.nodeURL = *nodeURL
+	}
+	if err := c.refreshSlots(ctx); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// ClusterDialer returns a store.Dialer for a Redis Cluster.
+func ClusterDialer(seeds []string, nodeURL *url.URL) store.Dialer {
+	return func(ctx context.Context) (store.Store, error) {
+		c, err := NewCluster(ctx, seeds, nodeURL)
+		if err != nil {
+			return nil, &store.ConnError{Err: err}
+		}
+		return c, nil
+	}
+}
+
+// refreshSlots reloads the slot map from CLUSTER SLOTS, trying the
+// currently known nodes before the seeds.
+func (c *Cluster) refreshSlots(ctx context.Context) error {
+	c.mu.RLock()
+	candidates := make([]string, 0, len(c.nodes)+len(c.seeds))
+	for addr := range c.nodes {
+		candidates = append(candidates, addr)
+	}
+	c.mu.RUnlock()
+	candidates = append(candidates, c.seeds...)
+
+	var errs []error
+	for _, addr := range candidates {
+		slots, err := c.clusterSlots(ctx, addr)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%q: %v", addr, err))
+			continue
+		}
+		c.mu.Lock()
+		c.slots = *slots
+		c.mu.Unlock()
+		return nil
+	}
+	return &store.ConnError{Err: errors.Join(errs...)}
+}
+
+func (c *Cluster) clusterSlots(ctx context.Context, addr string) (*[numSlots]string, error) {
+	rc, err := dialRESPAt(ctx, c.nodeURL, addr)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	reply, err := rc.do(ctx, "CLUSTER", "SLOTS")
+	if err != nil {
+		return nil, err
+	}
+
+	// Each range is [start, end, [master host, port, ...], replicas...]
+	ranges, _ := reply.([]interface{})
+	slots := new([numSlots]string)
+	for _, r := range ranges {
+		parts, _ := r.([]interface{})
+		if len(parts) < 3 {
+			return nil, errMalformedReply
+		}
+		start, ok1 := parts[0].(int64)
+		end, ok2 := parts[1].(int64)
+		master, _ := parts[2].([]interface{})
+		if !ok1 || !ok2 || len(master) < 2 || start < 0 || end >= numSlots {
+			return nil, errMalformedReply
+		}
+		host, _ := replyString(master[0])
+		port, ok := master[1].(int64)
+		if !ok {
+			return nil, errMalformedReply
+		}
+		if host == "" {
+			// An empty host means the node that replied.
+			host, _, _ = net.SplitHostPort(addr)
+		}
+		nodeAddr := net.JoinHostPort(host, strconv.FormatInt(port, 10))
+		for slot := start; slot <= end; slot++ {
+			slots[slot] = nodeAddr
+		}
+	}
+	return slots, nil
+}
+
+func (c *Cluster) node(key string) (*Client, error) {
+	slot := HashSlot(key)
+
+	c.mu.RLock()
+	if c.closed {
+		c.mu.RUnlock()
+		return nil, store.ErrClosed
+	}
+	addr := c.slots[slot]
+	c.mu.RUnlock()
+
+	if addr == "" {
+		return nil, fmt.Errorf("redisstore: slot %d is not served by any node", slot)
+	}
+	return c.nodeAt(addr)
+}
+
+// nodeAt returns the Client of the node at addr, connecting to it if need be.
+func (c *Cluster) nodeAt(addr string) (*Client, error) {
+	c.mu.RLock()
+	client, closed := c.nodes[addr], c.closed
+	c.mu.RUnlock()
+	if closed {
+		return nil, store.ErrClosed
+	}
+	if client != nil {
+		return client, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if client = c.nodes[addr]; client != nil {
+		return client, nil
+	}
+	u := c.nodeURL
+	u.Host = addr
+	client, err := New(u.String())
+	if err != nil {
+		return nil, &store.ConnError{Err: err}
+	}
+	c.nodes[addr] = client
+	return client, nil
+}
+
+// dropNode forgets a node whose connection broke.
+func (c *Cluster) dropNode(client *Client) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for addr, cl := range c.nodes {
+		if cl == client {
+			delete(c.nodes, addr)
+			cl.Close()
+		}
+	}
+}
+
+// redirection parses MOVED and ASK error replies, such
+// as "ASK 3999 127.0.0.1:6381", into their kind and the
+// address of the node that they redirect to.
+func redirection(err error) (kind, addr string) {
+	if err == nil {
+		return "", ""
+	}
+	fields := strings.Fields(err.Error())
+	if len(fields) != 3 || (fields[0] != "MOVED" && fields[0] != "ASK") {
+		return "", ""
+	}
+	return fields[0], fields[2]
+}
+
+// do runs fn against the node serving key. Should that node reply with
+// ASK, because the key has already been moved off a slot that is being
+// migrated, fn is retried once against the node named in the reply with
+// ASKING, leaving the slot map as is since the slot still belongs to the
+// old node. On MOVED or a broken connection the slot map is refreshed and
+// fn is retried once against the new owner.
+func (c *Cluster) do(ctx context.Context, key string, fn func(store.Store) error) error {
+	client, err := c.node(key)
+	if err != nil {
+		return err
+	}
+	err = fn(client)
+	kind, addr := redirection(err)
+	if kind == "ASK" {
+		target, err := c.nodeAt(addr)
+		if err != nil {
+			return err
+		}
+		return fn(&askingNode{target})
+	}
+	if kind != "MOVED" && !store.IsConnError(err) {
+		return err
+	}
+	if store.IsConnError(err) {
+		c.dropNode(client)
+	}
+	if err := c.refreshSlots(ctx); err != nil {
+		return err
+	}
+	if client, err = c.node(key); err != nil {
+		return err
+	}
+	return fn(client)
+}
+
+// askingNode runs each operation as raw commands preceded by ASKING on
+// the same connection, which is what a node that a slot is migrating to
+// requires before it serves the keys of that slot.
+type askingNode struct {
+	client *Client
+}
+
+var _ store.Store = (*askingNode)(nil)
+
+// hPopScript emulates HPop, which Redis does not have, atomically.
+const hPopScript = `local v = redis.call("HGET", KEYS[1], ARGV[1])
+if v then redis.call("HDEL", KEYS[1], ARGV[1]) end
+return v`
+
+func (an *askingNode) SAdd(ctx context.Context, set string, members ...string) error {
+	_, err := an.client.askingCommand(ctx, append([]string{"SADD", set}, members...)...)
+	return err
+}
+
+func (an *askingNode) SIsMember(ctx context.Context, set, member string) (bool, error) {
+	v, err := an.client.askingCommand(ctx, "SISMEMBER", set, member)
+	n, _ := v.(int64)
+	return n == 1, err
+}
+
+func (an *askingNode) SCard(ctx context.Context, set string) (int64, error) {
+	v, err := an.client.askingCommand(ctx, "SCARD", set)
+	n, _ := v.(int64)
+	return n, err
+}
+
+func (an *askingNode) HSet(ctx context.Context, table, key string, value []byte) error {
+	_, err := an.client.askingCommand(ctx, "HSET", table, key, string(value))
+	return err
+}
+
+func (an *askingNode) HGet(ctx context.Context, table, key string) ([]byte, error) {
+	return toBlob(an.client.askingCommand(ctx, "HGET", table, key))
+}
+
+func (an *askingNode) HPop(ctx context.Context, table, key string) ([]byte, error) {
+	return toBlob(an.client.askingCommand(ctx, "EVAL", hPopScript, "1", table, key))
+}
+
+func (an *askingNode) LPush(ctx context.Context, list string, values ...[]byte) error {
+	args := []string{"LPUSH", list}
+	for _, value := range values {
+		args = append(args, string(value))
+	}
+	_, err := an.client.askingCommand(ctx, args...)
+	return err
+}
+
+func (an *askingNode) SetEx(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return setEx(ctx, an.client.askingCommand, key, value, ttl)
+}
+
+func (an *askingNode) GetDel(ctx context.Context, key string) ([]byte, error) {
+	return getDel(ctx, an.client.askingCommand, key)
+}
+
+func (an *askingNode) Expire(ctx context.Context, key string, ttl time.Duration) error {
+	return expire(ctx, an.client.askingCommand, key, ttl)
+}
+
+// Close is a no-op as the Client is owned by the Cluster.
+func (an *askingNode) Close() error { return nil }
+
+func (c *Cluster) SAdd(ctx context.Context, set string, members ...string) error {
+	return c.do(ctx, set, func(st store.Store) error {
+		return st.SAdd(ctx, set, members...)
+	})
+}
+
+func (c *Cluster) SIsMember(ctx context.Context, set, member string) (ok bool, err error) {
+	err = c.do(ctx, set, func(st store.Store) (err error) {
+		ok, err = st.SIsMember(ctx, set, member)
+		return err
+	})
+	return ok, err
+}
+
+func (c *Cluster) SCard(ctx context.Context, set string) (n int64, err error) {
+	err = c.do(ctx, set, func(st store.Store) (err error) {
+		n, err = st.SCard(ctx, set)
+		return err
+	})
+	return n, err
+}
+
+func (c *Cluster) HSet(ctx context.Context, table, key string, value []byte) error {
+	return c.do(ctx, table, func(st store.Store) error {
+		return st.HSet(ctx, table, key, value)
+	})
+}
+
+func (c *Cluster) HGet(ctx context.Context, table, key string) (blob []byte, err error) {
+	err = c.do(ctx, table, func(st store.Store) (err error) {
+		blob, err = st.HGet(ctx, table, key)
+		return err
+	})
+	return blob, err
+}
+
+func (c *Cluster) HPop(ctx context.Context, table, key string) (blob []byte, err error) {
+	err = c.do(ctx, table, func(st store.Store) (err error) {
+		blob, err = st.HPop(ctx, table, key)
+		return err
+	})
+	return blob, err
+}
+
+func (c *Cluster) LPush(ctx context.Context, list string, values ...[]byte) error {
+	return c.do(ctx, list, func(st store.Store) error {
+		return st.LPush(ctx, list, values...)
+	})
+}
+
+func (c *Cluster) SetEx(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return c.do(ctx, key, func(st store.Store) error {
+		return st.SetEx(ctx, key, value, ttl)
+	})
+}
+
+func (c *Cluster) GetDel(ctx context.Context, key string) (blob []byte, err error) {
+	err = c.do(ctx, key, func(st store.Store) (err error) {
+		blob, err = st.GetDel(ctx, key)
+		return err
+	})
+	return blob, err
+}
+
+func (c *Cluster) Expire(ctx context.Context, key string, ttl time.Duration) error {
+	return c.do(ctx, key, func(st store.Store) error {
+		return st.Expire(ctx, key, ttl)
+	})
+}
+
+func (c *Cluster) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return store.ErrClosed
+	}
+	c.closed = true
+	var errs []error
+	for _, client := range c.nodes {
+		if err := client.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// HashSlot returns the Redis Cluster hash slot of key. Only the
+// substring within the first non-empty {hash tag} is hashed so that
+// related keys can be placed on the same slot.
+func HashSlot(key string) int {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	return int(crc16(key) % numSlots)
+}
+
+// crc16 is the CRC-16/XMODEM checksum used by Redis Cluster.
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc ^= uint16(s[i]) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
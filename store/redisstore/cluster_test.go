@@ -0,0 +1,365 @@
+package redisstore
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/odeke-em/uberclick/store"
+)
+
+func TestHashSlot(t *testing.T) {
+	tests := []struct {
+		key  string
+		want int
+	}{
+		// Reported by CLUSTER KEYSLOT.
+		{"foo", 12182},
+		{"bar", 5061},
+		{"somekey", 11058},
+		{"123456789", 12739},
+		{"", 0},
+
+		// Only the first non-empty {hash tag} is hashed.
+		{"{foo}", 12182},
+		{"{user1000}.following", HashSlot("user1000")},
+		{"{user1000}.followers", HashSlot("user1000")},
+		{"foo{bar}{zap}", HashSlot("bar")},
+		{"foo{{bar}}zap", HashSlot("{bar")},
+		{"foo{}{bar}", HashSlot("foo{}{bar}")},
+		{"foo{bar", HashSlot("foo{bar")},
+	}
+	for _, tt := range tests {
+		if got := HashSlot(tt.key); got != tt.want {
+			t.Errorf("HashSlot(%q) = %d, want %d", tt.key, got, tt.want)
+		}
+	}
+	if HashSlot("foo{}{bar}") == HashSlot("bar") {
+		t.Errorf("HashSlot(%q) hashed the tag after an empty one", "foo{}{bar}")
+	}
+}
+
+// fakeNode is an in-process Redis Cluster node that serves the
+// commands that Cluster sends over raw RESP connections.
+type fakeNode struct {
+	cluster *fakeCluster
+	ln      net.Listener
+
+	mu   sync.Mutex
+	data map[string][]byte
+	// importing are the keys that this node serves to ASKING
+	// commands only, as their slot is migrating to it.
+	importing map[string]bool
+	// migrated are the keys that this node answers with ASK,
+	// as they already moved to the node at the given address.
+	migrated map[string]string
+	calls    map[string]int
+}
+
+// fakeCluster assigns every slot to owner, whose
+// address it reports in reply to CLUSTER SLOTS.
+type fakeCluster struct {
+	mu    sync.Mutex
+	owner *fakeNode
+}
+
+func newFakeCluster(t *testing.T, n int) (*fakeCluster, []*fakeNode) {
+	fc := new(fakeCluster)
+	var nodes []*fakeNode
+	for i := 0; i < n; i++ {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		fn := &fakeNode{
+			cluster:   fc,
+			ln:        ln,
+			data:      make(map[string][]byte),
+			importing: make(map[string]bool),
+			migrated:  make(map[string]string),
+			calls:     make(map[string]int),
+		}
+		t.Cleanup(func() { ln.Close() })
+		go fn.serve()
+		nodes = append(nodes, fn)
+	}
+	fc.owner = nodes[0]
+	return fc, nodes
+}
+
+func (fc *fakeCluster) setOwner(fn *fakeNode) {
+	fc.mu.Lock()
+	fc.owner = fn
+	fc.mu.Unlock()
+}
+
+func (fc *fakeCluster) ownerAddr() string {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	return fc.owner.addr()
+}
+
+func (fn *fakeNode) addr() string { return fn.ln.Addr().String() }
+
+func (fn *fakeNode) callsOf(cmd string) int {
+	fn.mu.Lock()
+	defer fn.mu.Unlock()
+	return fn.calls[cmd]
+}
+
+func (fn *fakeNode) serve() {
+	for {
+		conn, err := fn.ln.Accept()
+		if err != nil {
+			return
+		}
+		go fn.serveConn(conn)
+	}
+}
+
+func (fn *fakeNode) serveConn(conn net.Conn) {
+	defer conn.Close()
+	rc := &respConn{conn: conn, rw: bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))}
+	asking := false
+	for {
+		v, err := rc.readReply()
+		if err != nil {
+			return
+		}
+		elems, _ := v.([]interface{})
+		var args []string
+		for _, elem := range elems {
+			arg, _ := replyString(elem)
+			args = append(args, arg)
+		}
+		if len(args) == 0 {
+			return
+		}
+		cmd := strings.ToUpper(args[0])
+		if cmd == "ASKING" {
+			asking = true
+			fmt.Fprintf(rc.rw, "+OK\r\n")
+		} else {
+			fn.reply(rc.rw, cmd, args[1:], asking)
+			asking = false
+		}
+		if err := rc.rw.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func (fn *fakeNode) reply(w *bufio.ReadWriter, cmd string, args []string, asking bool) {
+	fn.mu.Lock()
+	defer fn.mu.Unlock()
+	fn.calls[cmd]++
+
+	if cmd == "CLUSTER" {
+		host, port, _ := net.SplitHostPort(fn.cluster.ownerAddr())
+		fmt.Fprintf(w, "*1\r\n*3\r\n:0\r\n:%d\r\n*2\r\n$%d\r\n%s\r\n:%s\r\n", numSlots-1, len(host), host, port)
+		return
+	}
+	if len(args) == 0 {
+		fmt.Fprintf(w, "-ERR wrong number of arguments\r\n")
+		return
+	}
+	key := args[0]
+	slot := HashSlot(key)
+	switch owner := fn.cluster.ownerAddr(); {
+	case fn.migrated[key] != "":
+		fmt.Fprintf(w, "-ASK %d %s\r\n", slot, fn.migrated[key])
+		return
+	case fn.importing[key] && !asking:
+		fmt.Fprintf(w, "-MOVED %d %s\r\n", slot, owner)
+		return
+	case owner != fn.addr() && !fn.importing[key]:
+		fmt.Fprintf(w, "-MOVED %d %s\r\n", slot, owner)
+		return
+	}
+
+	switch cmd {
+	case "SET":
+		fn.data[key] = []byte(args[1])
+		fmt.Fprintf(w, "+OK\r\n")
+	case "GETDEL":
+		value, ok := fn.data[key]
+		delete(fn.data, key)
+		if !ok {
+			fmt.Fprintf(w, "$-1\r\n")
+			return
+		}
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(value), value)
+	case "PEXPIRE":
+		if _, ok := fn.data[key]; ok {
+			fmt.Fprintf(w, ":1\r\n")
+		} else {
+			fmt.Fprintf(w, ":0\r\n")
+		}
+	default:
+		fmt.Fprintf(w, "-ERR unknown command '%s'\r\n", cmd)
+	}
+}
+
+func (fn *fakeNode) get(key string) ([]byte, bool) {
+	fn.mu.Lock()
+	defer fn.mu.Unlock()
+	value, ok := fn.data[key]
+	return value, ok
+}
+
+func newTestCluster(t *testing.T, fc *fakeCluster) *Cluster {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c, err := NewCluster(ctx, []string{fc.ownerAddr()}, nil)
+	if err != nil {
+		t.Fatalf("NewCluster: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestClusterMoved(t *testing.T) {
+	fc, nodes := newFakeCluster(t, 2)
+	a, b := nodes[0], nodes[1]
+	c := newTestCluster(t, fc)
+	ctx := context.Background()
+
+	// The slot map that c loaded is stale once b owns every slot.
+	fc.setOwner(b)
+	if err := c.SetEx(ctx, "key", []byte("value"), time.Minute); err != nil {
+		t.Fatalf("SetEx: %v", err)
+	}
+	if value, ok := b.get("key"); !ok || string(value) != "value" {
+		t.Fatalf("new owner holds %q, %t; want %q", value, ok, "value")
+	}
+	if got := a.callsOf("CLUSTER") + b.callsOf("CLUSTER"); got != 2 {
+		t.Errorf("CLUSTER SLOTS calls = %d, want 2 as MOVED refreshes the slot map", got)
+	}
+
+	// The refreshed map sends commands straight to b.
+	if _, err := c.GetDel(ctx, "key"); err != nil {
+		t.Fatalf("GetDel: %v", err)
+	}
+	if got := a.callsOf("GETDEL"); got != 0 {
+		t.Errorf("old owner got %d GETDELs, want none", got)
+	}
+}
+
+func TestClusterAsk(t *testing.T) {
+	fc, nodes := newFakeCluster(t, 2)
+	a, b := nodes[0], nodes[1]
+	c := newTestCluster(t, fc)
+	ctx := context.Background()
+
+	// "key" has already been migrated from a to b but a
+	// still owns its slot until the migration completes.
+	a.mu.Lock()
+	a.migrated["key"] = b.addr()
+	a.mu.Unlock()
+	b.mu.Lock()
+	b.importing["key"] = true
+	b.data["key"] = []byte("value")
+	b.mu.Unlock()
+
+	blob, err := c.GetDel(ctx, "key")
+	if err != nil {
+		t.Fatalf("GetDel: %v", err)
+	}
+	if string(blob) != "value" {
+		t.Errorf("GetDel = %q, want %q", blob, "value")
+	}
+	if got := a.callsOf("CLUSTER") + b.callsOf("CLUSTER"); got != 1 {
+		t.Errorf("CLUSTER SLOTS calls = %d, want 1 as ASK leaves the slot map as is", got)
+	}
+	if got := b.callsOf("GETDEL"); got != 1 {
+		t.Errorf("importing node got %d GETDELs, want 1", got)
+	}
+
+	// Keys of the slot that have not moved are still served by a.
+	if err := c.SetEx(ctx, "other{key}", []byte("v"), time.Minute); err != nil {
+		t.Fatalf("SetEx: %v", err)
+	}
+	if _, ok := a.get("other{key}"); !ok {
+		t.Errorf("old owner lacks a key of the slot that did not move")
+	}
+
+	// Without ASKING, the importing node refuses the key.
+	_, err = b.client(t).GetDel(ctx, "key")
+	if kind, _ := redirection(err); kind != "MOVED" {
+		t.Errorf("GetDel on the importing node without ASKING = %v, want MOVED", err)
+	}
+	if err := c.Expire(ctx, "missing", time.Minute); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Expire of a missing key = %v, want %v", err, store.ErrNotFound)
+	}
+}
+
+func (fn *fakeNode) client(t *testing.T) *Client {
+	client, err := New("redis://" + fn.addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestRedirection(t *testing.T) {
+	tests := []struct {
+		err        error
+		kind, addr string
+	}{
+		{respError("MOVED 3999 127.0.0.1:6381"), "MOVED", "127.0.0.1:6381"},
+		{respError("ASK 3999 127.0.0.1:6381"), "ASK", "127.0.0.1:6381"},
+		{respError("ERR unknown command 'GETDEL'"), "", ""},
+		{errors.New("ASKING failed"), "", ""},
+		{nil, "", ""},
+	}
+	for _, tt := range tests {
+		kind, addr := redirection(tt.err)
+		if kind != tt.kind || addr != tt.addr {
+			t.Errorf("redirection(%v) = (%q, %q), want (%q, %q)", tt.err, kind, addr, tt.kind, tt.addr)
+		}
+	}
+}
+
+// TestClusterURLTLS checks that rediss-cluster URLs reach the
+// nodes over TLS, which the plaintext fake nodes cannot serve.
+func TestClusterURLTLS(t *testing.T) {
+	fc, nodes := newFakeCluster(t, 1)
+	tests := []struct {
+		scheme string
+		tls    bool
+	}{
+		{"redis-cluster", false},
+		{"rediss-cluster", true},
+	}
+	for _, tt := range tests {
+		dial, err := DialerFromURL(tt.scheme + "://" + fc.ownerAddr())
+		if err != nil {
+			t.Fatalf("DialerFromURL(%s): %v", tt.scheme, err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		calls := nodes[0].callsOf("CLUSTER")
+		st, err := dial(ctx)
+		cancel()
+		if tt.tls {
+			if err == nil {
+				st.Close()
+				t.Errorf("%s: dialed a plaintext node", tt.scheme)
+			}
+			if nodes[0].callsOf("CLUSTER") != calls {
+				t.Errorf("%s: sent CLUSTER SLOTS in plaintext", tt.scheme)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", tt.scheme, err)
+		}
+		st.Close()
+	}
+}
@@ -0,0 +1,285 @@
+// Package redisstore implements store.Store on top of a Redis server.
+package redisstore
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/odeke-em/redtable"
+
+	"github.com/odeke-em/uberclick/store"
+)
+
+// Client is a single connection to a Redis server. Errors caused by
+// the connection breaking are reported as *store.ConnError after which
+// the Client should be replaced, typically by using it through a
+// store.Reconnecting built with Dialer.
+type Client struct {
+	url string
+
+	mu     sync.Mutex
+	conn   *redtable.Client
+	closed bool
+
+	// rc serves the commands that redtable does not expose.
+	rcMu sync.Mutex
+	rc   *respConn
+}
+
+var _ store.Store = (*Client)(nil)
+
+// New connects to the Redis server at url
+// e.g. redis://localhost:6379
+func New(url string) (*Client, error) {
+	conn, err := redtable.New(url)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{url: url, conn: conn}, nil
+}
+
+// Dialer returns a store.Dialer connecting to the Redis server at url.
+func Dialer(url string) store.Dialer {
+	return func(ctx context.Context) (store.Store, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		c, err := New(url)
+		if err != nil {
+			return nil, &store.ConnError{Err: err}
+		}
+		return c, nil
+	}
+}
+
+func (c *Client) client() (*redtable.Client, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil, store.ErrClosed
+	}
+	return c.conn, nil
+}
+
+type result struct {
+	value interface{}
+	err   error
+}
+
+// do runs fn on the connection. Since redtable cannot be interrupted,
+// fn is left to complete in the background if ctx is done first.
+func (c *Client) do(ctx context.Context, fn func(*redtable.Client) (interface{}, error)) (interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	conn, err := c.client()
+	if err != nil {
+		return nil, err
+	}
+	resChan := make(chan *result, 1)
+	go func() {
+		v, err := fn(conn)
+		if err != nil && conn.ConnErr() != nil {
+			err = &store.ConnError{Err: err}
+		}
+		resChan <- &result{value: v, err: err}
+	}()
+
+	select {
+	case res := <-resChan:
+		return res.value, res.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (c *Client) SAdd(ctx context.Context, set string, members ...string) error {
+	var args []interface{}
+	for _, member := range members {
+		args = append(args, member)
+	}
+	_, err := c.do(ctx, func(conn *redtable.Client) (interface{}, error) {
+		return conn.SAdd(set, args...)
+	})
+	return err
+}
+
+func (c *Client) SIsMember(ctx context.Context, set, member string) (bool, error) {
+	v, err := c.do(ctx, func(conn *redtable.Client) (interface{}, error) {
+		return conn.SIsMember(set, member)
+	})
+	ok, _ := v.(bool)
+	return ok, err
+}
+
+func (c *Client) SCard(ctx context.Context, set string) (int64, error) {
+	v, err := c.command(ctx, "SCARD", set)
+	n, _ := v.(int64)
+	return n, err
+}
+
+func (c *Client) HSet(ctx context.Context, table, key string, value []byte) error {
+	_, err := c.do(ctx, func(conn *redtable.Client) (interface{}, error) {
+		return conn.HSet(table, key, string(value))
+	})
+	return err
+}
+
+func (c *Client) HGet(ctx context.Context, table, key string) ([]byte, error) {
+	return toBlob(c.do(ctx, func(conn *redtable.Client) (interface{}, error) {
+		return conn.HGet(table, key)
+	}))
+}
+
+func (c *Client) HPop(ctx context.Context, table, key string) ([]byte, error) {
+	return toBlob(c.do(ctx, func(conn *redtable.Client) (interface{}, error) {
+		return conn.HPop(table, key)
+	}))
+}
+
+func (c *Client) LPush(ctx context.Context, list string, values ...[]byte) error {
+	var args []interface{}
+	for _, value := range values {
+		args = append(args, value)
+	}
+	_, err := c.do(ctx, func(conn *redtable.Client) (interface{}, error) {
+		return conn.LPush(list, args...)
+	})
+	return err
+}
+
+// command runs a raw command on the auxiliary connection.
+func (c *Client) command(ctx context.Context, args ...string) (interface{}, error) {
+	return c.rawCommand(ctx, false, args)
+}
+
+// askingCommand is like command but first sends ASKING, as a cluster
+// node requires of commands that another node redirected to it with
+// ASK while their slot migrates.
+func (c *Client) askingCommand(ctx context.Context, args ...string) (interface{}, error) {
+	return c.rawCommand(ctx, true, args)
+}
+
+func (c *Client) rawCommand(ctx context.Context, asking bool, args []string) (v interface{}, err error) {
+	if _, err := c.client(); err != nil {
+		return nil, err
+	}
+	c.rcMu.Lock()
+	defer c.rcMu.Unlock()
+
+	if c.rc == nil {
+		rc, err := dialRESPURL(ctx, c.url)
+		if err != nil {
+			return nil, &store.ConnError{Err: err}
+		}
+		c.rc = rc
+	}
+	if asking {
+		_, err = c.rc.do(ctx, "ASKING")
+	}
+	if err == nil {
+		v, err = c.rc.do(ctx, args...)
+	}
+	if _, isReply := err.(respError); err != nil && !isReply {
+		// The connection is in an unknown state after
+		// an I/O error or timeout, so discard it.
+		c.rc.Close()
+		c.rc = nil
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, &store.ConnError{Err: err}
+	}
+	return v, err
+}
+
+// commandFunc runs a raw command, such as Client.command.
+type commandFunc func(ctx context.Context, args ...string) (interface{}, error)
+
+func (c *Client) SetEx(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return setEx(ctx, c.command, key, value, ttl)
+}
+
+func (c *Client) GetDel(ctx context.Context, key string) ([]byte, error) {
+	return getDel(ctx, c.command, key)
+}
+
+func (c *Client) Expire(ctx context.Context, key string, ttl time.Duration) error {
+	return expire(ctx, c.command, key, ttl)
+}
+
+func setEx(ctx context.Context, command commandFunc, key string, value []byte, ttl time.Duration) error {
+	ms := strconv.FormatInt(ttl.Milliseconds(), 10)
+	_, err := command(ctx, "SET", key, string(value), "PX", ms)
+	return err
+}
+
+// getDelScript emulates GETDEL on servers older than Redis 6.2.
+const getDelScript = `local v = redis.call("GET", KEYS[1])
+if v then redis.call("DEL", KEYS[1]) end
+return v`
+
+func getDel(ctx context.Context, command commandFunc, key string) ([]byte, error) {
+	v, err := command(ctx, "GETDEL", key)
+	if re, ok := err.(respError); ok && strings.HasPrefix(string(re), "ERR unknown command") {
+		v, err = command(ctx, "EVAL", getDelScript, "1", key)
+	}
+	return toBlob(v, err)
+}
+
+func expire(ctx context.Context, command commandFunc, key string, ttl time.Duration) error {
+	ms := strconv.FormatInt(ttl.Milliseconds(), 10)
+	v, err := command(ctx, "PEXPIRE", key, ms)
+	if err != nil {
+		return err
+	}
+	if n, _ := v.(int64); n == 0 {
+		return store.ErrNotFound
+	}
+	return nil
+}
+
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return store.ErrClosed
+	}
+	c.closed = true
+
+	c.rcMu.Lock()
+	if c.rc != nil {
+		c.rc.Close()
+		c.rc = nil
+	}
+	c.rcMu.Unlock()
+	return c.conn.Close()
+}
+
+// toBlob converts a reply from Redis which could
+// be either a bulk string or nil into bytes.
+func toBlob(v interface{}, err error) ([]byte, error) {
+	if err != nil {
+		return nil, err
+	}
+	var blob []byte
+	switch v := v.(type) {
+	case nil:
+	case []byte:
+		blob = v
+	case string:
+		blob = []byte(v)
+	default:
+		blob = []byte(fmt.Sprintf("%s", v))
+	}
+	if len(blob) == 0 {
+		return nil, store.ErrNotFound
+	}
+	return blob, nil
+}
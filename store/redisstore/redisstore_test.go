@@ -0,0 +1,27 @@
+package redisstore_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/odeke-em/uberclick/store"
+	"github.com/odeke-em/uberclick/store/redisstore"
+	"github.com/odeke-em/uberclick/store/storetest"
+)
+
+// TestConformance runs against the Redis server at
+// UBERCLICK_TEST_REDIS_URL, e.g. redis://localhost:6379,
+// and is skipped if it is unset.
+func TestConformance(t *testing.T) {
+	url := os.Getenv("UBERCLICK_TEST_REDIS_URL")
+	if url == "" {
+		t.Skip("UBERCLICK_TEST_REDIS_URL is not set")
+	}
+	storetest.Run(t, func(t *testing.T) store.Store {
+		st, err := redisstore.New(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return st
+	})
+}
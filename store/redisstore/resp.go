@@ -0,0 +1,187 @@
+package redisstore
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// respConn is a minimal RESP2 connection used for the commands
+// that redtable does not expose, such as SENTINEL and CLUSTER.
+type respConn struct {
+	conn net.Conn
+	rw   *bufio.ReadWriter
+}
+
+// respError is an error reply sent by the server.
+type respError string
+
+func (re respError) Error() string { return string(re) }
+
+var errMalformedReply = errors.New("redisstore: malformed reply")
+
+func dialRESP(ctx context.Context, addr string) (*respConn, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	rc := &respConn{
+		conn: conn,
+		rw:   bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn)),
+	}
+	return rc, nil
+}
+
+// dialRESPURL connects to the server of a redis:// or rediss:// URL
+// and authenticates and selects the database that the URL names.
+func dialRESPURL(ctx context.Context, rawURL string) (*respConn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	rc, err := dialRESP(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "rediss" {
+		tlsConn := tls.Client(rc.conn, &tls.Config{ServerName: u.Hostname()})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			rc.Close()
+			return nil, err
+		}
+		rc.conn = tlsConn
+		rc.rw = bufio.NewReadWriter(bufio.NewReader(tlsConn), bufio.NewWriter(tlsConn))
+	}
+	if pass := password(u); pass != "" {
+		if _, err := rc.do(ctx, "AUTH", pass); err != nil {
+			rc.Close()
+			return nil, err
+		}
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" && db != "0" {
+		if _, err := rc.do(ctx, "SELECT", db); err != nil {
+			rc.Close()
+			return nil, err
+		}
+	}
+	return rc, nil
+}
+
+// dialRESPAt connects to addr the way that nodeURL describes,
+// over TLS for rediss and with its password and database.
+func dialRESPAt(ctx context.Context, nodeURL url.URL, addr string) (*respConn, error) {
+	nodeURL.Host = addr
+	return dialRESPURL(ctx, nodeURL.String())
+}
+
+func (rc *respConn) Close() error { return rc.conn.Close() }
+
+// do sends a command and reads its reply, which is one of nil,
+// string, int64, []byte, []interface{} or a respError.
+func (rc *respConn) do(ctx context.Context, args ...string) (interface{}, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Time{}
+	}
+	if err := rc.conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	fmt.Fprintf(rc.rw, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(rc.rw, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if err := rc.rw.Flush(); err != nil {
+		return nil, err
+	}
+	reply, err := rc.readReply()
+	if err != nil {
+		return nil, err
+	}
+	if re, ok := reply.(respError); ok {
+		return nil, re
+	}
+	return reply, nil
+}
+
+func (rc *respConn) readLine() (string, error) {
+	line, err := rc.rw.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return "", errMalformedReply
+	}
+	return line[:len(line)-2], nil
+}
+
+func (rc *respConn) readReply() (interface{}, error) {
+	line, err := rc.readLine()
+	if err != nil {
+		return nil, err
+	}
+	kind, rest := line[0], line[1:]
+	switch kind {
+	case '+':
+		return rest, nil
+	case '-':
+		return respError(rest), nil
+	case ':':
+		return strconv.ParseInt(rest, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(rest)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		blob := make([]byte, n+2)
+		if _, err := io.ReadFull(rc.rw, blob); err != nil {
+			return nil, err
+		}
+		return blob[:n], nil
+	case '*':
+		n, err := strconv.Atoi(rest)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		elems := make([]interface{}, n)
+		for i := range elems {
+			if elems[i], err = rc.readReply(); err != nil {
+				return nil, err
+			}
+		}
+		return elems, nil
+	default:
+		return nil, errMalformedReply
+	}
+}
+
+// replyString converts bulk and simple string replies to a string.
+func replyString(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case []byte:
+		return string(v), true
+	default:
+		return "", false
+	}
+}
@@ -0,0 +1,109 @@
+package redisstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/odeke-em/uberclick/store"
+)
+
+var errNoSentinels = errors.New("redisstore: expecting at least one sentinel address")
+
+// SentinelDialer returns a store.Dialer that asks each of the sentinels
+// in turn for the address of the master named masterName and connects
+// to it. masterURL supplies the scheme, credentials and database of the
+// master and may be nil. Used through a store.Reconnecting, a failover
+// is followed as soon as the old master's connection breaks.
+func SentinelDialer(sentinels []string, masterName string, masterURL *url.URL) store.Dialer {
+	nodeURL := url.URL{Scheme: "redis"}
+	if masterURL != nil {
+		nodeURL = *masterURL
+	}
+	// Sentinels are reached the same way as the master,
+	// over TLS if it is, but without its credentials.
+	sentinelURL := url.URL{Scheme: nodeURL.Scheme}
+	return func(ctx context.Context) (store.Store, error) {
+		if len(sentinels) == 0 {
+			return nil, errNoSentinels
+		}
+		var errs []error
+		for _, sentinel := range sentinels {
+			addr, err := masterAddr(ctx, sentinelURL, sentinel, masterName)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("sentinel %q: %v", sentinel, err))
+				continue
+			}
+			if err := checkRole(ctx, nodeURL, addr, "master"); err != nil {
+				// The sentinel has not yet noticed a failover.
+				errs = append(errs, fmt.Errorf("sentinel %q: master %q: %v", sentinel, addr, err))
+				continue
+			}
+
+			u := nodeURL
+			u.Host = addr
+			c, err := New(u.String())
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			return c, nil
+		}
+		return nil, &store.ConnError{Err: errors.Join(errs...)}
+	}
+}
+
+func masterAddr(ctx context.Context, sentinelURL url.URL, sentinel, masterName string) (string, error) {
+	rc, err := dialRESPAt(ctx, sentinelURL, sentinel)
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+
+	reply, err := rc.do(ctx, "SENTINEL", "get-master-addr-by-name", masterName)
+	if err != nil {
+		return "", err
+	}
+	parts, _ := reply.([]interface{})
+	if len(parts) != 2 {
+		return "", fmt.Errorf("unknown master %q", masterName)
+	}
+	host, ok1 := replyString(parts[0])
+	port, ok2 := replyString(parts[1])
+	if !ok1 || !ok2 {
+		return "", errMalformedReply
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+func password(u *url.URL) string {
+	if u == nil || u.User == nil {
+		return ""
+	}
+	pass, _ := u.User.Password()
+	return pass
+}
+
+func checkRole(ctx context.Context, nodeURL url.URL, addr, want string) error {
+	rc, err := dialRESPAt(ctx, nodeURL, addr)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	reply, err := rc.do(ctx, "ROLE")
+	if err != nil {
+		return err
+	}
+	parts, _ := reply.([]interface{})
+	if len(parts) == 0 {
+		return errMalformedReply
+	}
+	if role, _ := replyString(parts[0]); !strings.EqualFold(role, want) {
+		return fmt.Errorf("has role %q, expected %q", role, want)
+	}
+	return nil
+}
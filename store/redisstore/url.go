@@ -0,0 +1,53 @@
+package redisstore
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/odeke-em/uberclick/store"
+)
+
+// DialerFromURL returns the store.Dialer described by rawURL which is
+// one of:
+//
+//	redis://[:password@]host:port[/db]
+//	redis-sentinel://[:password@]host1:port1,host2:port2/masterName[?db=N]
+//	redis-cluster://[:password@]host1:port1,host2:port2
+//
+// For Sentinel and Cluster the password is that of the data nodes.
+// The rediss, rediss-sentinel and rediss-cluster schemes connect the
+// same way over TLS, to the sentinels as well as to the data nodes.
+func DialerFromURL(rawURL string) (store.Dialer, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	nodeURL := &url.URL{Scheme: "redis", User: u.User}
+	if strings.HasPrefix(u.Scheme, "rediss") {
+		nodeURL.Scheme = "rediss"
+	}
+	hosts := strings.Split(u.Host, ",")
+
+	switch u.Scheme {
+	case "redis", "rediss":
+		return Dialer(rawURL), nil
+
+	case "redis-sentinel", "rediss-sentinel":
+		masterName := strings.Trim(u.Path, "/")
+		if masterName == "" {
+			return nil, fmt.Errorf("redisstore: %q: expecting the master name as the path", rawURL)
+		}
+		if db := u.Query().Get("db"); db != "" {
+			nodeURL.Path = "/" + db
+		}
+		return SentinelDialer(hosts, masterName, nodeURL), nil
+
+	case "redis-cluster", "rediss-cluster":
+		return ClusterDialer(hosts, nodeURL), nil
+
+	default:
+		return nil, fmt.Errorf("redisstore: %q: unsupported scheme %q", rawURL, u.Scheme)
+	}
+}
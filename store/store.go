@@ -0,0 +1,70 @@
+// Package store defines the storage operations that uberclick relies on
+// so that the server can run against Redis or any other implementation.
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned when a hash field does not exist.
+	ErrNotFound = errors.New("store: no such key")
+	// ErrClosed is returned by operations on a closed store.
+	ErrClosed = errors.New("store: closed")
+)
+
+// Store is the set, hash and list subset of Redis
+// semantics used by the uberclick server.
+// Operations return ctx.Err() once ctx is done.
+type Store interface {
+	// SAdd adds members to the named set.
+	SAdd(ctx context.Context, set string, members ...string) error
+	// SIsMember reports whether member belongs to the named set.
+	SIsMember(ctx context.Context, set, member string) (bool, error)
+	// SCard returns the number of members of the named
+	// set, which is zero if the set does not exist.
+	SCard(ctx context.Context, set string) (int64, error)
+
+	// HSet sets the field key of the named hash table to value.
+	HSet(ctx context.Context, table, key string, value []byte) error
+	// HGet returns the value of field key of the named hash
+	// table or ErrNotFound if the field does not exist.
+	HGet(ctx context.Context, table, key string) ([]byte, error)
+	// HPop is like HGet but also deletes the field.
+	HPop(ctx context.Context, table, key string) ([]byte, error)
+
+	// LPush prepends values to the named list.
+	LPush(ctx context.Context, list string, values ...[]byte) error
+
+	// SetEx sets the string key to value and deletes it once ttl elapses.
+	SetEx(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// GetDel atomically returns and deletes the string key
+	// so that only one caller can ever consume its value.
+	// It returns ErrNotFound if key does not exist.
+	GetDel(ctx context.Context, key string) ([]byte, error)
+
+	// Expire deletes key, which can name a set, hash, list or
+	// string, once ttl elapses. It returns ErrNotFound if key
+	// does not exist.
+	Expire(ctx context.Context, key string, ttl time.Duration) error
+
+	Close() error
+}
+
+// ConnError wraps errors caused by a broken connection to the
+// backing server, after which the Store must be redialed.
+type ConnError struct {
+	Err error
+}
+
+func (ce *ConnError) Error() string { return "store: connection error: " + ce.Err.Error() }
+
+func (ce *ConnError) Unwrap() error { return ce.Err }
+
+// IsConnError reports whether err was caused by a broken connection.
+func IsConnError(err error) bool {
+	var ce *ConnError
+	return errors.As(err, &ce)
+}
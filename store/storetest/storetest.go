@@ -0,0 +1,385 @@
+// Package storetest is a conformance suite for store.Store
+// implementations. A backend verifies itself by calling Run
+// from its own tests, for example:
+//
+//	func TestConformance(t *testing.T) {
+//		storetest.Run(t, func(t *testing.T) store.Store {
+//			st, err := filestore.Open(filepath.Join(t.TempDir(), "db"), nil)
+//			if err != nil {
+//				t.Fatal(err)
+//			}
+//			return st
+//		})
+//	}
+//
+// Keys are prefixed uniquely per run so that a shared
+// Redis server can be used, and Run closes every Store
+// that it obtains.
+package storetest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/odeke-em/uberclick/store"
+)
+
+// Factory returns a Store that Run may close.
+type Factory func(t *testing.T) store.Store
+
+// ttl is long enough for a networked store to see a key
+// before it expires yet short enough to keep the suite fast.
+const ttl = 300 * time.Millisecond
+
+func Run(t *testing.T, newStore Factory) {
+	tests := []struct {
+		name string
+		fn   func(*testing.T, store.Store, func(string) string)
+	}{
+		{"Sets", testSets},
+		{"Hashes", testHashes},
+		{"HPop", testHPop},
+		{"ConcurrentHPop", testConcurrentHPop},
+		{"GetDel", testGetDel},
+		{"ConcurrentGetDel", testConcurrentGetDel},
+		{"SetExExpiry", testSetExExpiry},
+		{"LPush", testLPush},
+		{"Expire", testExpire},
+		{"CancelledContext", testCancelledContext},
+		{"Closed", testClosed},
+	}
+
+	prefix := fmt.Sprintf("storetest-%d", time.Now().UnixNano())
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			st := newStore(t)
+			if tt.name != "Closed" {
+				defer st.Close()
+			}
+			key := func(name string) string {
+				return prefix + "-" + tt.name + "-" + name
+			}
+			tt.fn(t, st, key)
+		})
+	}
+}
+
+func testSets(t *testing.T, st store.Store, key func(string) string) {
+	ctx := context.Background()
+	set := key("set")
+
+	if ok, err := st.SIsMember(ctx, set, "a"); err != nil || ok {
+		t.Fatalf("SIsMember on a missing set = (%v, %v), want (false, nil)", ok, err)
+	}
+	if err := st.SAdd(ctx, set, "a", "b"); err != nil {
+		t.Fatalf("SAdd: %v", err)
+	}
+	// Adding existing members is not an error.
+	if err := st.SAdd(ctx, set, "b", "c"); err != nil {
+		t.Fatalf("SAdd of an existing member: %v", err)
+	}
+	for member, want := range map[string]bool{"a": true, "b": true, "c": true, "d": false} {
+		if ok, err := st.SIsMember(ctx, set, member); err != nil || ok != want {
+			t.Errorf("SIsMember(%q) = (%v, %v), want (%v, nil)", member, ok, err, want)
+		}
+	}
+	if ok, err := st.SIsMember(ctx, key("other"), "a"); err != nil || ok {
+		t.Errorf("SIsMember leaked across sets: (%v, %v)", ok, err)
+	}
+	if n, err := st.SCard(ctx, set); err != nil || n != 3 {
+		t.Errorf("SCard = (%d, %v), want (3, nil)", n, err)
+	}
+	if n, err := st.SCard(ctx, key("other")); err != nil || n != 0 {
+		t.Errorf("SCard of a missing set = (%d, %v), want (0, nil)", n, err)
+	}
+}
+
+func testHashes(t *testing.T, st store.Store, key func(string) string) {
+	ctx := context.Background()
+	table := key("hash")
+
+	if _, err := st.HGet(ctx, table, "f"); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("HGet on a missing hash err = %v, want ErrNotFound", err)
+	}
+	value := []byte(`{"access_token":"x"}`)
+	if err := st.HSet(ctx, table, "f", value); err != nil {
+		t.Fatalf("HSet: %v", err)
+	}
+	got, err := st.HGet(ctx, table, "f")
+	if err != nil || !bytes.Equal(got, value) {
+		t.Fatalf("HGet = (%q, %v), want (%q, nil)", got, err, value)
+	}
+	// HGet does not consume the field.
+	if _, err := st.HGet(ctx, table, "f"); err != nil {
+		t.Fatalf("second HGet: %v", err)
+	}
+	if _, err := st.HGet(ctx, table, "g"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("HGet of a missing field err = %v, want ErrNotFound", err)
+	}
+
+	updated := []byte("updated")
+	if err := st.HSet(ctx, table, "f", updated); err != nil {
+		t.Fatalf("HSet overwrite: %v", err)
+	}
+	if got, err := st.HGet(ctx, table, "f"); err != nil || !bytes.Equal(got, updated) {
+		t.Errorf("HGet after overwrite = (%q, %v), want (%q, nil)", got, err, updated)
+	}
+}
+
+func testHPop(t *testing.T, st store.Store, key func(string) string) {
+	ctx := context.Background()
+	table := key("hash")
+
+	if _, err := st.HPop(ctx, table, "f"); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("HPop on a missing hash err = %v, want ErrNotFound", err)
+	}
+	if err := st.HSet(ctx, table, "f", []byte("v")); err != nil {
+		t.Fatalf("HSet: %v", err)
+	}
+	if err := st.HSet(ctx, table, "g", []byte("w")); err != nil {
+		t.Fatalf("HSet: %v", err)
+	}
+	if got, err := st.HPop(ctx, table, "f"); err != nil || string(got) != "v" {
+		t.Fatalf("HPop = (%q, %v), want (\"v\", nil)", got, err)
+	}
+	if _, err := st.HPop(ctx, table, "f"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("second HPop err = %v, want ErrNotFound", err)
+	}
+	if _, err := st.HGet(ctx, table, "f"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("HGet after HPop err = %v, want ErrNotFound", err)
+	}
+	if got, err := st.HGet(ctx, table, "g"); err != nil || string(got) != "w" {
+		t.Errorf("HPop disturbed another field: (%q, %v)", got, err)
+	}
+}
+
+// testConcurrentHPop checks that a popped field,
+// such as a revoked token, is only ever returned once.
+func testConcurrentHPop(t *testing.T, st store.Store, key func(string) string) {
+	ctx := context.Background()
+	table := key("state")
+
+	const states, poppers = 10, 8
+	for i := 0; i < states; i++ {
+		if err := st.HSet(ctx, table, fmt.Sprint(i), []byte("nonce")); err != nil {
+			t.Fatalf("HSet: %v", err)
+		}
+	}
+
+	var mu sync.Mutex
+	wins := make(map[string]int)
+	var wg sync.WaitGroup
+	for p := 0; p < poppers; p++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < states; i++ {
+				field := fmt.Sprint(i)
+				_, err := st.HPop(ctx, table, field)
+				switch {
+				case err == nil:
+					mu.Lock()
+					wins[field]++
+					mu.Unlock()
+				case !errors.Is(err, store.ErrNotFound):
+					t.Errorf("HPop(%q): %v", field, err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i := 0; i < states; i++ {
+		if n := wins[fmt.Sprint(i)]; n != 1 {
+			t.Errorf("state %d was popped %d times, want exactly once", i, n)
+		}
+	}
+}
+
+func testGetDel(t *testing.T, st store.Store, key func(string) string) {
+	ctx := context.Background()
+	k := key("state")
+
+	if _, err := st.GetDel(ctx, k); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("GetDel of a missing key err = %v, want ErrNotFound", err)
+	}
+	if err := st.SetEx(ctx, k, []byte("first"), time.Hour); err != nil {
+		t.Fatalf("SetEx: %v", err)
+	}
+	if err := st.SetEx(ctx, k, []byte("second"), time.Hour); err != nil {
+		t.Fatalf("SetEx overwrite: %v", err)
+	}
+	if got, err := st.GetDel(ctx, k); err != nil || string(got) != "second" {
+		t.Fatalf("GetDel = (%q, %v), want (\"second\", nil)", got, err)
+	}
+	if _, err := st.GetDel(ctx, k); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("second GetDel err = %v, want ErrNotFound", err)
+	}
+}
+
+// testConcurrentGetDel checks that OAuth2.0 state, which is
+// consumed with GetDel, can only ever be consumed once.
+func testConcurrentGetDel(t *testing.T, st store.Store, key func(string) string) {
+	ctx := context.Background()
+
+	const states, consumers = 10, 8
+	for i := 0; i < states; i++ {
+		if err := st.SetEx(ctx, key(fmt.Sprint(i)), []byte("nonce"), time.Hour); err != nil {
+			t.Fatalf("SetEx: %v", err)
+		}
+	}
+
+	var mu sync.Mutex
+	wins := make(map[int]int)
+	var wg sync.WaitGroup
+	for c := 0; c < consumers; c++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < states; i++ {
+				_, err := st.GetDel(ctx, key(fmt.Sprint(i)))
+				switch {
+				case err == nil:
+					mu.Lock()
+					wins[i]++
+					mu.Unlock()
+				case !errors.Is(err, store.ErrNotFound):
+					t.Errorf("GetDel(%d): %v", i, err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i := 0; i < states; i++ {
+		if n := wins[i]; n != 1 {
+			t.Errorf("state %d was consumed %d times, want exactly once", i, n)
+		}
+	}
+}
+
+func testSetExExpiry(t *testing.T, st store.Store, key func(string) string) {
+	ctx := context.Background()
+	k := key("state")
+
+	if err := st.SetEx(ctx, k, []byte("v"), ttl); err != nil {
+		t.Fatalf("SetEx: %v", err)
+	}
+	time.Sleep(2 * ttl)
+	if _, err := st.GetDel(ctx, k); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("GetDel after the TTL err = %v, want ErrNotFound", err)
+	}
+}
+
+func testLPush(t *testing.T, st store.Store, key func(string) string) {
+	ctx := context.Background()
+	list := key("list")
+
+	if err := st.LPush(ctx, list, []byte("a"), []byte("b")); err != nil {
+		t.Fatalf("LPush: %v", err)
+	}
+	if err := st.LPush(ctx, list, []byte("c")); err != nil {
+		t.Fatalf("LPush onto an existing list: %v", err)
+	}
+	// The list exists now and so can be given a TTL.
+	if err := st.Expire(ctx, list, time.Hour); err != nil {
+		t.Errorf("Expire of a pushed list: %v", err)
+	}
+}
+
+func testExpire(t *testing.T, st store.Store, key func(string) string) {
+	ctx := context.Background()
+	set, table, list := key("set"), key("hash"), key("list")
+
+	if err := st.Expire(ctx, key("missing"), ttl); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Expire of a missing key err = %v, want ErrNotFound", err)
+	}
+
+	if err := st.SAdd(ctx, set, "a"); err != nil {
+		t.Fatalf("SAdd: %v", err)
+	}
+	if err := st.HSet(ctx, table, "f", []byte("v")); err != nil {
+		t.Fatalf("HSet: %v", err)
+	}
+	if err := st.LPush(ctx, list, []byte("v")); err != nil {
+		t.Fatalf("LPush: %v", err)
+	}
+	for _, k := range []string{set, table, list} {
+		if err := st.Expire(ctx, k, ttl); err != nil {
+			t.Fatalf("Expire(%q): %v", k, err)
+		}
+	}
+
+	if ok, err := st.SIsMember(ctx, set, "a"); err != nil || !ok {
+		t.Errorf("set expired early: (%v, %v)", ok, err)
+	}
+	if _, err := st.HGet(ctx, table, "f"); err != nil {
+		t.Errorf("hash expired early: %v", err)
+	}
+
+	time.Sleep(2 * ttl)
+
+	if ok, err := st.SIsMember(ctx, set, "a"); err != nil || ok {
+		t.Errorf("set survived its TTL: (%v, %v)", ok, err)
+	}
+	if _, err := st.HGet(ctx, table, "f"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("hash survived its TTL, err = %v", err)
+	}
+	if err := st.Expire(ctx, list, ttl); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("list survived its TTL, err = %v", err)
+	}
+
+	// A key recreated after expiring starts without a TTL.
+	if err := st.HSet(ctx, table, "f", []byte("v")); err != nil {
+		t.Fatalf("HSet: %v", err)
+	}
+	time.Sleep(2 * ttl)
+	if _, err := st.HGet(ctx, table, "f"); err != nil {
+		t.Errorf("recreated hash inherited the old TTL: %v", err)
+	}
+}
+
+func testCancelledContext(t *testing.T, st store.Store, key func(string) string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := st.HSet(ctx, key("hash"), "f", []byte("v")); !errors.Is(err, context.Canceled) {
+		t.Errorf("HSet with a cancelled context err = %v, want context.Canceled", err)
+	}
+	if _, err := st.HGet(ctx, key("hash"), "f"); !errors.Is(err, context.Canceled) {
+		t.Errorf("HGet with a cancelled context err = %v, want context.Canceled", err)
+	}
+}
+
+func testClosed(t *testing.T, st store.Store, key func(string) string) {
+	ctx := context.Background()
+	if err := st.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	checks := map[string]error{
+		"SAdd":   st.SAdd(ctx, key("set"), "a"),
+		"HSet":   st.HSet(ctx, key("hash"), "f", []byte("v")),
+		"LPush":  st.LPush(ctx, key("list"), []byte("v")),
+		"Expire": st.Expire(ctx, key("hash"), ttl),
+		"SetEx":  st.SetEx(ctx, key("state"), []byte("v"), ttl),
+		"Close":  st.Close(),
+	}
+	_, checks["SIsMember"] = st.SIsMember(ctx, key("set"), "a")
+	_, checks["SCard"] = st.SCard(ctx, key("set"))
+	_, checks["HGet"] = st.HGet(ctx, key("hash"), "f")
+	_, checks["HPop"] = st.HPop(ctx, key("hash"), "f")
+	_, checks["GetDel"] = st.GetDel(ctx, key("state"))
+
+	for op, err := range checks {
+		if !errors.Is(err, store.ErrClosed) {
+			t.Errorf("%s after Close err = %v, want ErrClosed", op, err)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+// Package tracing provides the OpenTelemetry tracer providers of uberclick:
+// one that exports spans over OTLP for deployments, and one that keeps
+// them in memory so that tests can inspect the spans of their requests.
+package tracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// NewOTLP returns a provider that exports spans in batches over
+// OTLP/HTTP to endpoint, e.g. "http://localhost:4318". Traces that
+// do not continue a sampled parent are sampled at sampleRatio.
+func NewOTLP(ctx context.Context, endpoint string, sampleRatio float64) (*sdktrace.TracerProvider, error) {
+	exp, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(endpoint))
+	if err != nil {
+		return nil, err
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+		sdktrace.WithBatcher(exp),
+	)
+	return tp, nil
+}
+
+// NewInMemory returns a provider that samples every span and exports
+// it to the returned exporter synchronously, as soon as it ends.
+func NewInMemory() (*sdktrace.TracerProvider, *tracetest.InMemoryExporter) {
+	exp := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithSampler(sdktrace.AlwaysSample()),
+		sdktrace.WithSyncer(exp),
+	)
+	return tp, exp
+}
+
+// SpanNames returns the names of the spans held by exp in the order
+// that they ended, which is convenient for asserting on their shape.
+func SpanNames(exp *tracetest.InMemoryExporter) []string {
+	var names []string
+	for _, span := range exp.GetSpans() {
+		names = append(names, span.Name)
+	}
+	return names
+}
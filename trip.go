@@ -0,0 +1,211 @@
+package uberclick
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+)
+
+// Trip is where an estimate or a ride starts and ends. Each end is
+// given either by coordinates or by the ID of a saved place, never both.
+type Trip struct {
+	StartLatitude  float64 `json:"start_latitude,omitempty"`
+	StartLongitude float64 `json:"start_longitude,omitempty"`
+	StartPlace     string  `json:"start_place_id,omitempty"`
+
+	EndLatitude  float64 `json:"end_latitude,omitempty"`
+	EndLongitude float64 `json:"end_longitude,omitempty"`
+	EndPlace     string  `json:"end_place_id,omitempty"`
+
+	SeatCount int `json:"seat_count,omitempty"`
+}
+
+const (
+	// MaxTripKilometres is the longest straight line
+	// distance between the start and end of a trip.
+	MaxTripKilometres = 200
+
+	MinSeatCount = 1
+	MaxSeatCount = 2
+)
+
+var (
+	ErrInvalidCoordinates = &Err{
+		Code:   CodeInvalidCoordinates,
+		Reason: "invalid coordinates",
+	}
+	ErrMissingLocation = &Err{
+		Code:    CodeMissingLocation,
+		Reason:  "missing location",
+		Details: "expecting either coordinates or a place ID",
+	}
+	ErrConflictingLocation = &Err{
+		Code:    CodeConflictingLocation,
+		Reason:  "conflicting location",
+		Details: "expecting either coordinates or a place ID but not both",
+	}
+	ErrIdenticalEndpoints = &Err{
+		Code:    CodeIdenticalEndpoints,
+		Reason:  "identical start and end",
+		Details: "expecting the trip to end elsewhere than it starts",
+	}
+	ErrTripTooLong = &Err{
+		Code:    CodeTripTooLong,
+		Reason:  "trip too long",
+		Details: fmt.Sprintf("expecting a trip of at most %dkm", MaxTripKilometres),
+	}
+	ErrInvalidSeatCount = &Err{
+		Code:    CodeInvalidSeatCount,
+		Reason:  "invalid seat count",
+		Details: fmt.Sprintf("expecting between %d and %d seats", MinSeatCount, MaxSeatCount),
+	}
+)
+
+// TripOf extracts the Trip described by v, such
+// as an uber.EstimateRequest or uber.RideRequest.
+func TripOf(v interface{}) (*Trip, error) {
+	blob, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	trip := new(Trip)
+	if err := json.Unmarshal(blob, trip); err != nil {
+		return nil, err
+	}
+	return trip, nil
+}
+
+// ValidateEstimate reports every problem of t as a trip to
+// estimate, which unlike a ride must have a known end.
+func (t *Trip) ValidateEstimate() *WrappedError { return t.validate(true) }
+
+// ValidateRide reports every problem of t as a ride to request
+// or a pickup to estimate, for which the end is optional.
+func (t *Trip) ValidateRide() *WrappedError { return t.validate(false) }
+
+func (t *Trip) validate(requireEnd bool) (we *WrappedError) {
+	var errsList []*Err
+
+	defer func() {
+		if len(errsList) > 0 {
+			we = &WrappedError{Errors: errsList}
+		}
+	}()
+
+	if t == nil {
+		errsList = append(errsList, errBlankSubmission)
+		return
+	}
+
+	start := endpoint{"start", t.StartLatitude, t.StartLongitude, t.StartPlace}
+	end := endpoint{"end", t.EndLatitude, t.EndLongitude, t.EndPlace}
+
+	startErrs := start.validate(true)
+	endErrs := end.validate(requireEnd)
+	errsList = append(errsList, startErrs...)
+	errsList = append(errsList, endErrs...)
+
+	if t.SeatCount != 0 && (t.SeatCount < MinSeatCount || t.SeatCount > MaxSeatCount) {
+		errsList = append(errsList, fieldErr(ErrInvalidSeatCount, "seat_count"))
+	}
+
+	if len(startErrs) > 0 || len(endErrs) > 0 || end.blank() {
+		return
+	}
+	switch {
+	case start.place != "" && start.place == end.place:
+		errsList = append(errsList, fieldErr(ErrIdenticalEndpoints, "end_place_id"))
+	case start.place != "" || end.place != "":
+		// Places cannot be located here, so
+		// their distance cannot be checked.
+	case start.kilometresTo(end) < 0.001:
+		errsList = append(errsList, fieldErr(ErrIdenticalEndpoints, "end_latitude"))
+	case start.kilometresTo(end) > MaxTripKilometres:
+		errsList = append(errsList, fieldErr(ErrTripTooLong, "end_latitude"))
+	}
+	return
+}
+
+// ValidateLocation reports every problem of lat and lng as the
+// coordinates of a location, such as where products are listed.
+func ValidateLocation(lat, lng float64) *WrappedError {
+	if errsList := (endpoint{lat: lat, lng: lng}).validate(true); len(errsList) > 0 {
+		return &WrappedError{Errors: errsList}
+	}
+	return nil
+}
+
+type endpoint struct {
+	name     string
+	lat, lng float64
+	place    string
+}
+
+func (e endpoint) kilometresTo(o endpoint) float64 {
+	return KilometresBetween(e.lat, e.lng, o.lat, o.lng)
+}
+
+func (e endpoint) blank() bool {
+	return e.lat == 0 && e.lng == 0 && e.place == ""
+}
+
+// field names the input of e that f is about, such as
+// "start_latitude", or just f if e is not a trip end.
+func (e endpoint) field(f string) string {
+	if e.name == "" {
+		return f
+	}
+	return e.name + "_" + f
+}
+
+func (e endpoint) validate(required bool) []*Err {
+	hasCoords := e.lat != 0 || e.lng != 0
+	switch {
+	case e.blank():
+		if !required {
+			return nil
+		}
+		// (0, 0) lies in the ocean so is taken
+		// to be coordinates that were never set.
+		return []*Err{fieldErr(ErrMissingLocation, e.field("latitude"))}
+	case hasCoords && e.place != "":
+		return []*Err{fieldErr(ErrConflictingLocation, e.field("place_id"))}
+	case e.place != "":
+		if !IsSavedPlace(e.place) {
+			return []*Err{fieldErr(ErrInvalidPlace, e.field("place_id"))}
+		}
+		return nil
+	}
+
+	var errsList []*Err
+	if e.lat < -90 || e.lat > 90 {
+		ev := fieldErr(ErrInvalidCoordinates, e.field("latitude"))
+		ev.Details = "expecting a latitude between -90 and 90"
+		errsList = append(errsList, ev)
+	}
+	if e.lng < -180 || e.lng > 180 {
+		ev := fieldErr(ErrInvalidCoordinates, e.field("longitude"))
+		ev.Details = "expecting a longitude between -180 and 180"
+		errsList = append(errsList, ev)
+	}
+	return errsList
+}
+
+func fieldErr(e *Err, field string) *Err {
+	ec := *e
+	ec.Field = field
+	return &ec
+}
+
+const earthRadiusKilometres = 6371
+
+// KilometresBetween is the great-circle distance
+// between the points (lat1, lng1) and (lat2, lng2).
+func KilometresBetween(lat1, lng1, lat2, lng2 float64) float64 {
+	rad := func(deg float64) float64 { return deg * math.Pi / 180 }
+	dLat := rad(lat2 - lat1)
+	dLng := rad(lng2 - lng1)
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(rad(lat1))*math.Cos(rad(lat2))*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadiusKilometres * math.Asin(math.Sqrt(h))
+}
@@ -0,0 +1,175 @@
+package uberclick_test
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/odeke-em/uberclick"
+)
+
+// San Francisco and Los Angeles, some 560km apart.
+const (
+	sfLat, sfLng = 37.7752315, -122.418075
+	laLat, laLng = 34.0522342, -118.2436849
+)
+
+// fieldCodes flattens we into "field:code" pairs.
+func fieldCodes(we *uberclick.WrappedError) []string {
+	if we == nil {
+		return nil
+	}
+	var got []string
+	for _, e := range we.Errors {
+		got = append(got, e.Field+":"+string(e.Code))
+	}
+	return got
+}
+
+func TestValidateTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		trip *uberclick.Trip
+		// estimate and ride are the problems reported
+		// by ValidateEstimate and ValidateRide.
+		estimate, ride []string
+	}{
+		{
+			name:     "nil",
+			estimate: []string{":invalid_submission"},
+			ride:     []string{":invalid_submission"},
+		},
+		{
+			name: "coordinates",
+			trip: &uberclick.Trip{StartLatitude: sfLat, StartLongitude: sfLng, EndLatitude: sfLat + 0.1, EndLongitude: sfLng},
+		},
+		{
+			name:     "no end",
+			trip:     &uberclick.Trip{StartLatitude: sfLat, StartLongitude: sfLng},
+			estimate: []string{"end_latitude:missing_location"},
+		},
+		{
+			name:     "unset start",
+			trip:     &uberclick.Trip{EndLatitude: sfLat, EndLongitude: sfLng},
+			estimate: []string{"start_latitude:missing_location"},
+			ride:     []string{"start_latitude:missing_location"},
+		},
+		{
+			name:     "latitude out of range",
+			trip:     &uberclick.Trip{StartLatitude: 90.5, StartLongitude: sfLng, EndLatitude: sfLat, EndLongitude: sfLng},
+			estimate: []string{"start_latitude:invalid_coordinates"},
+			ride:     []string{"start_latitude:invalid_coordinates"},
+		},
+		{
+			name:     "longitudes out of range",
+			trip:     &uberclick.Trip{StartLatitude: sfLat, StartLongitude: -180.5, EndLatitude: -91, EndLongitude: 181},
+			estimate: []string{"start_longitude:invalid_coordinates", "end_latitude:invalid_coordinates", "end_longitude:invalid_coordinates"},
+			ride:     []string{"start_longitude:invalid_coordinates", "end_latitude:invalid_coordinates", "end_longitude:invalid_coordinates"},
+		},
+		{
+			name: "range bounds",
+			trip: &uberclick.Trip{StartLatitude: 90, StartLongitude: 180, EndLatitude: 89.9, EndLongitude: 180},
+		},
+		{
+			name:     "identical coordinates",
+			trip:     &uberclick.Trip{StartLatitude: sfLat, StartLongitude: sfLng, EndLatitude: sfLat, EndLongitude: sfLng},
+			estimate: []string{"end_latitude:identical_endpoints"},
+			ride:     []string{"end_latitude:identical_endpoints"},
+		},
+		{
+			name:     "too long",
+			trip:     &uberclick.Trip{StartLatitude: sfLat, StartLongitude: sfLng, EndLatitude: laLat, EndLongitude: laLng},
+			estimate: []string{"end_latitude:trip_too_long"},
+			ride:     []string{"end_latitude:trip_too_long"},
+		},
+		{
+			name: "places",
+			trip: &uberclick.Trip{StartPlace: uberclick.PlaceHome, EndPlace: uberclick.PlaceWork},
+		},
+		{
+			name: "place and coordinates",
+			trip: &uberclick.Trip{StartPlace: uberclick.PlaceHome, EndLatitude: laLat, EndLongitude: laLng},
+		},
+		{
+			name:     "identical places",
+			trip:     &uberclick.Trip{StartPlace: uberclick.PlaceWork, EndPlace: uberclick.PlaceWork},
+			estimate: []string{"end_place_id:identical_endpoints"},
+			ride:     []string{"end_place_id:identical_endpoints"},
+		},
+		{
+			name:     "unknown place",
+			trip:     &uberclick.Trip{StartPlace: "gym", EndLatitude: sfLat, EndLongitude: sfLng},
+			estimate: []string{"start_place_id:invalid_place"},
+			ride:     []string{"start_place_id:invalid_place"},
+		},
+		{
+			name:     "place conflicting with coordinates",
+			trip:     &uberclick.Trip{StartLatitude: sfLat, StartLongitude: sfLng, EndLatitude: laLat, EndPlace: uberclick.PlaceHome},
+			estimate: []string{"end_place_id:conflicting_location"},
+			ride:     []string{"end_place_id:conflicting_location"},
+		},
+		{
+			name: "seats",
+			trip: &uberclick.Trip{StartLatitude: sfLat, StartLongitude: sfLng, EndLatitude: sfLat + 0.1, EndLongitude: sfLng, SeatCount: uberclick.MaxSeatCount},
+		},
+		{
+			name:     "too many seats",
+			trip:     &uberclick.Trip{StartLatitude: sfLat, StartLongitude: sfLng, EndLatitude: sfLat + 0.1, EndLongitude: sfLng, SeatCount: uberclick.MaxSeatCount + 1},
+			estimate: []string{"seat_count:invalid_seat_count"},
+			ride:     []string{"seat_count:invalid_seat_count"},
+		},
+		{
+			name:     "negative seats",
+			trip:     &uberclick.Trip{StartLatitude: sfLat, StartLongitude: sfLng, SeatCount: -1},
+			estimate: []string{"end_latitude:missing_location", "seat_count:invalid_seat_count"},
+			ride:     []string{"seat_count:invalid_seat_count"},
+		},
+	}
+	for _, tt := range tests {
+		if got := fieldCodes(tt.trip.ValidateEstimate()); !reflect.DeepEqual(got, tt.estimate) {
+			t.Errorf("%s: ValidateEstimate = %v, want %v", tt.name, got, tt.estimate)
+		}
+		if got := fieldCodes(tt.trip.ValidateRide()); !reflect.DeepEqual(got, tt.ride) {
+			t.Errorf("%s: ValidateRide = %v, want %v", tt.name, got, tt.ride)
+		}
+	}
+}
+
+func TestValidateLocation(t *testing.T) {
+	tests := []struct {
+		lat, lng float64
+		want     []string
+	}{
+		{sfLat, sfLng, nil},
+		{-90, -180, nil},
+		{0, 0, []string{"latitude:missing_location"}},
+		{-90.1, sfLng, []string{"latitude:invalid_coordinates"}},
+		{sfLat, 180.1, []string{"longitude:invalid_coordinates"}},
+	}
+	for _, tt := range tests {
+		if got := fieldCodes(uberclick.ValidateLocation(tt.lat, tt.lng)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ValidateLocation(%v, %v) = %v, want %v", tt.lat, tt.lng, got, tt.want)
+		}
+	}
+}
+
+func TestKilometresBetween(t *testing.T) {
+	tests := []struct {
+		lat1, lng1, lat2, lng2 float64
+		want                   float64
+	}{
+		{sfLat, sfLng, sfLat, sfLng, 0},
+		{sfLat, sfLng, laLat, laLng, 559},
+		// A degree of latitude anywhere, and of longitude at the equator.
+		{0, 0, 1, 0, 111.2},
+		{0, 0, 0, 1, 111.2},
+		// Across the antimeridian.
+		{0, 179.5, 0, -179.5, 111.2},
+	}
+	for _, tt := range tests {
+		got := uberclick.KilometresBetween(tt.lat1, tt.lng1, tt.lat2, tt.lng2)
+		if math.Abs(got-tt.want) > 1 {
+			t.Errorf("KilometresBetween(%v, %v, %v, %v) = %.1f, want %.1f", tt.lat1, tt.lng1, tt.lat2, tt.lng2, got, tt.want)
+		}
+	}
+}
@@ -1,15 +1,18 @@
 package uberclick
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
-	"io/ioutil"
-	"log"
+	"strconv"
 	"strings"
 
 	"github.com/odeke-em/go-uuid"
-	"github.com/odeke-em/redtable"
+
+	"github.com/odeke-em/uberclick/logging"
+	"github.com/odeke-em/uberclick/store"
 )
 
 type Submission struct {
@@ -24,24 +27,20 @@ type WrappedError struct {
 }
 
 type Err struct {
+	// Code is a stable machine-readable
+	// identifier for the kind of error.
+	Code Code `json:"code,omitempty"`
+	// Field, if set, is the input field at fault.
+	Field   string      `json:"field,omitempty"`
 	Details string      `json:"details,omitempty"`
 	Reason  string      `json:"reason,omitempty"`
 	Meta    interface{} `json:"meta,omitempty"`
 }
 
 var (
-	errBlankSubmission = &Err{
-		Reason:  "invalid data",
-		Details: "expecting a non-blank submission",
-	}
-	errBlankNonce = &Err{
-		Reason:  "invalid/blank nonce",
-		Details: "expecting a valid nonce",
-	}
-	errBlankAPIKey = &Err{
-		Reason:  "invalid/blank apiKey",
-		Details: "expecting a valid apiKey",
-	}
+	errBlankSubmission = ErrInvalidSubmission
+	errBlankNonce      = ErrInvalidNonce
+	errBlankAPIKey     = ErrInvalidAPIKey
 )
 
 func (s *Submission) Validate() (we *WrappedError) {
@@ -81,11 +80,7 @@ func (s *Submission) validateAPIKey() *Err {
 	return nil
 }
 
-var (
-	blankSubmission Submission
-
-	errFailedToParseSubmission = errors.New("failed to parse submission")
-)
+var blankSubmission Submission
 
 func GenerateNonce(subm *Submission) (*Submission, *WrappedError) {
 	if err := subm.validateAPIKey(); err != nil {
@@ -95,35 +90,85 @@ func GenerateNonce(subm *Submission) (*Submission, *WrappedError) {
 	return outSubm, nil
 }
 
+// FparseSubmission decodes a non-blank Submission from r.
+// Malformed input is reported as a *WrappedError.
 func FparseSubmission(r io.Reader) (*Submission, error) {
-	slurp, err := ioutil.ReadAll(r)
-	if err != nil {
-		return nil, err
-	}
 	subm := new(Submission)
-	if err := json.Unmarshal(slurp, subm); err != nil {
+	if err := DecodeJSON(r, subm); err != nil {
 		return nil, err
 	}
 	if *subm == blankSubmission {
-		return nil, errFailedToParseSubmission
+		return nil, &WrappedError{Errors: []*Err{errBlankSubmission}}
 	}
 	return subm, nil
 }
 
+// DecodeJSON decodes exactly one JSON value from r into recv,
+// rejecting fields that recv does not have. Malformed input is
+// reported as a *WrappedError whose entry names the offending
+// field, if any; failures to read r are returned as they are.
+func DecodeJSON(r io.Reader, recv interface{}) error {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(recv); err != nil {
+		return decodeError(err)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		if err != nil && !isSyntaxError(err) {
+			return err
+		}
+		return &WrappedError{Errors: []*Err{{
+			Code:    CodeInvalidBody,
+			Reason:  ErrInvalidBody.Reason,
+			Details: "expecting a single JSON value",
+		}}}
+	}
+	return nil
+}
+
+func isSyntaxError(err error) bool {
+	var se *json.SyntaxError
+	return errors.As(err, &se)
+}
+
+func decodeError(err error) error {
+	e := &Err{Code: CodeInvalidBody, Reason: ErrInvalidBody.Reason}
+
+	var se *json.SyntaxError
+	var te *json.UnmarshalTypeError
+	switch {
+	case err == io.EOF:
+		e.Details = "expecting a non-blank body"
+	case err == io.ErrUnexpectedEOF:
+		e.Details = "unexpected end of JSON"
+	case errors.As(err, &se):
+		e.Details = fmt.Sprintf("malformed JSON at offset %d", se.Offset)
+	case errors.As(err, &te):
+		e.Field = te.Field
+		e.Details = fmt.Sprintf("expecting %s, got %s", te.Type, te.Value)
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		e.Field, _ = strconv.Unquote(strings.TrimPrefix(err.Error(), "json: unknown field "))
+		e.Details = "unknown field"
+	default:
+		return err
+	}
+	return &WrappedError{Errors: []*Err{e}}
+}
+
 type RedisAPIKeyRegistration struct {
 	APIKey string `json:"api_key"`
 }
 
 func (reg *RedisAPIKeyRegistration) tableName() string { return reg.APIKey }
 
-func (reg *RedisAPIKeyRegistration) RegisterDomains(store *redtable.Client, domains ...string) error {
-	tableName := reg.tableName()
-	var addArgs []interface{}
-	for _, domain := range domains {
-		addArgs = append(addArgs, domain)
-	}
-	_, err := store.SAdd(tableName, addArgs...)
-	return err
+func (reg *RedisAPIKeyRegistration) RegisterDomains(ctx context.Context, st store.Store, domains ...string) error {
+	return st.SAdd(ctx, reg.tableName(), domains...)
+}
+
+// Registered reports whether any domains are registered for the API key.
+func (reg *RedisAPIKeyRegistration) Registered(ctx context.Context, st store.Store) (bool, error) {
+	n, err := st.SCard(ctx, reg.tableName())
+	return n > 0, err
 }
 
 type LookupResult struct {
@@ -142,13 +187,13 @@ func makeStringsIndex(sl []string) map[string]bool {
 
 const AnyDomain = "*"
 
-func isSMember(store *redtable.Client, sTableName string, key interface{}) (bool, error) {
-	return store.SIsMember(sTableName, key)
+func isSMember(ctx context.Context, st store.Store, sTableName string, key string) (bool, error) {
+	return st.SIsMember(ctx, sTableName, key)
 }
 
-func (reg *RedisAPIKeyRegistration) FilterAllowedDomain(store *redtable.Client, domains ...string) (allowed, notAllowed []string, err error) {
+func (reg *RedisAPIKeyRegistration) FilterAllowedDomain(ctx context.Context, st store.Store, domains ...string) (allowed, notAllowed []string, err error) {
 	tableName := reg.tableName()
-	anyDomainAllowed, err := isSMember(store, tableName, AnyDomain)
+	anyDomainAllowed, err := isSMember(ctx, st, tableName, AnyDomain)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -158,19 +203,19 @@ func (reg *RedisAPIKeyRegistration) FilterAllowedDomain(store *redtable.Client,
 
 	for _, domain := range domains {
 		ptr := &notAllowed
-		if ok, err := isSMember(store, tableName, domain); ok && err == nil {
+		if ok, err := isSMember(ctx, st, tableName, domain); ok && err == nil {
 			ptr = &allowed
 		}
 		*ptr = append(*ptr, domain)
 	}
 
-	log.Printf("tableName: %q allowed: %v notAllowed: %v\n", tableName, allowed, notAllowed)
+	logging.FromContext(ctx).DebugContext(ctx, "filtered domains",
+		"api_key", logging.Secret(reg.APIKey), "allowed", allowed, "not_allowed", notAllowed)
 	return allowed, notAllowed, nil
 }
 
-func (reg *RedisAPIKeyRegistration) AllowedDomain(store *redtable.Client, domain string) (bool, error) {
-	log.Printf("aa domain: %q\n", domain)
-	allowed, _, err := reg.FilterAllowedDomain(store, domain)
+func (reg *RedisAPIKeyRegistration) AllowedDomain(ctx context.Context, st store.Store, domain string) (bool, error) {
+	allowed, _, err := reg.FilterAllowedDomain(ctx, st, domain)
 	if err != nil {
 		return false, err
 	}
@@ -0,0 +1,224 @@
+package uberfake
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Product is a canned product offered by the fake
+// along with the prices and ETAs that it quotes.
+type Product struct {
+	ID          string
+	DisplayName string
+	Description string
+	Capacity    int
+	Shared      bool
+
+	LowEstimate    float64
+	HighEstimate   float64
+	DurationSecs   int
+	DistanceMiles  float64
+	PickupETASecs  int
+	UpfrontFareUSD float64
+}
+
+// DefaultProducts returns the canned products that
+// a Server offers unless SetProducts says otherwise.
+func DefaultProducts() []*Product {
+	return []*Product{
+		{
+			ID: "uberx-fake", DisplayName: "uberX", Description: "Affordable rides, all to yourself",
+			Capacity: 4, LowEstimate: 12, HighEstimate: 16, DurationSecs: 900,
+			DistanceMiles: 4.2, PickupETASecs: 240, UpfrontFareUSD: 14.3,
+		},
+		{
+			ID: "uberxl-fake", DisplayName: "uberXL", Description: "Affordable rides for groups up to 6",
+			Capacity: 6, LowEstimate: 20, HighEstimate: 26, DurationSecs: 900,
+			DistanceMiles: 4.2, PickupETASecs: 420, UpfrontFareUSD: 22.9,
+		},
+		{
+			ID: "pool-fake", DisplayName: "POOL", Description: "Share the ride, split the cost",
+			Capacity: 2, Shared: true, LowEstimate: 7, HighEstimate: 9, DurationSecs: 1200,
+			DistanceMiles: 4.2, PickupETASecs: 180, UpfrontFareUSD: 8.1,
+		},
+	}
+}
+
+// SetProducts replaces the products that s offers.
+func (s *Server) SetProducts(products ...*Product) {
+	s.mu.Lock()
+	s.products = append([]*Product(nil), products...)
+	s.mu.Unlock()
+}
+
+// Products returns the products that s offers.
+func (s *Server) Products() []*Product {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*Product(nil), s.products...)
+}
+
+func (s *Server) findProduct(id string) *Product {
+	for _, p := range s.Products() {
+		if p.ID == id {
+			return p
+		}
+	}
+	return nil
+}
+
+// productNotFound is the reply of the Uber API
+// to lookups of products that it does not offer.
+func productNotFound(id string) *Response {
+	return &Response{
+		Status: http.StatusNotFound,
+		Body: map[string]interface{}{
+			"code":    "not_found",
+			"message": fmt.Sprintf("Product %q not found", id),
+		},
+	}
+}
+
+func (s *Server) defaultProducts(req *http.Request) interface{} {
+	var products []map[string]interface{}
+	for _, p := range s.Products() {
+		group := "uberx"
+		if p.Shared {
+			group = "rideshare"
+		}
+		products = append(products, map[string]interface{}{
+			"product_id":           p.ID,
+			"display_name":         p.DisplayName,
+			"description":          p.Description,
+			"capacity":             p.Capacity,
+			"shared":               p.Shared,
+			"image":                "https://d1a3f4spazzrp4.cloudfront.net/car-types/mono/mono-uberx.png",
+			"upfront_fare_enabled": true,
+			"cash_enabled":         false,
+			"product_group":        group,
+		})
+	}
+	return map[string]interface{}{"products": products}
+}
+
+func (s *Server) defaultPriceEstimates(req *http.Request) interface{} {
+	var prices []map[string]interface{}
+	for _, p := range s.Products() {
+		prices = append(prices, map[string]interface{}{
+			"product_id":             p.ID,
+			"display_name":           p.DisplayName,
+			"localized_display_name": p.DisplayName,
+			"currency_code":          "USD",
+			"estimate":               "$" + ftoa(p.LowEstimate) + "-" + ftoa(p.HighEstimate),
+			"low_estimate":           p.LowEstimate,
+			"high_estimate":          p.HighEstimate,
+			"minimum":                p.LowEstimate,
+			"surge_multiplier":       1.0,
+			"duration":               p.DurationSecs,
+			"distance":               p.DistanceMiles,
+		})
+	}
+	return map[string]interface{}{"prices": prices}
+}
+
+func (s *Server) defaultTimeEstimates(req *http.Request) interface{} {
+	// Like Uber, only the product asked for, if any, is listed.
+	productID := req.URL.Query().Get("product_id")
+	var times []map[string]interface{}
+	for _, p := range s.Products() {
+		if productID != "" && p.ID != productID {
+			continue
+		}
+		times = append(times, map[string]interface{}{
+			"product_id":             p.ID,
+			"display_name":           p.DisplayName,
+			"localized_display_name": p.DisplayName,
+			"estimate":               p.PickupETASecs,
+		})
+	}
+	return map[string]interface{}{"times": times}
+}
+
+func productIDFromBody(req *http.Request) string {
+	var body struct {
+		ProductID string `json:"product_id"`
+	}
+	decodeJSON(req, &body)
+	return body.ProductID
+}
+
+func (s *Server) defaultUpfrontFare(req *http.Request) interface{} {
+	id := productIDFromBody(req)
+	p := s.findProduct(id)
+	if p == nil {
+		return productNotFound(id)
+	}
+	return map[string]interface{}{
+		"fare": map[string]interface{}{
+			"value":         p.UpfrontFareUSD,
+			"fare_id":       "fare-" + p.ID,
+			"expires_at":    time.Now().Add(2 * time.Minute).Unix(),
+			"display":       "$" + ftoa(p.UpfrontFareUSD),
+			"currency_code": "USD",
+		},
+		"trip": map[string]interface{}{
+			"distance_unit":     "mile",
+			"duration_estimate": p.DurationSecs,
+			"distance_estimate": p.DistanceMiles,
+		},
+		"pickup_estimate": p.PickupETASecs / 60,
+	}
+}
+
+func (s *Server) defaultRide(req *http.Request) interface{} {
+	id := productIDFromBody(req)
+	p := s.findProduct(id)
+	if p == nil {
+		return productNotFound(id)
+	}
+	return map[string]interface{}{
+		"request_id":       "ride-" + p.ID,
+		"product_id":       p.ID,
+		"status":           "processing",
+		"eta":              p.PickupETASecs / 60,
+		"surge_multiplier": 1.0,
+	}
+}
+
+func defaultProfile(req *http.Request) interface{} {
+	return map[string]interface{}{
+		"uuid":            "fake-rider-uuid",
+		"rider_id":        "fake-rider-id",
+		"first_name":      "Uber",
+		"last_name":       "Developer",
+		"email":           "developer@uber.com",
+		"mobile_verified": true,
+		"promo_code":      "uberd340ue",
+	}
+}
+
+// SavedPlaces are the addresses that the rider has saved by
+// default, keyed by place ID. Updates are kept per Server.
+var SavedPlaces = map[string]string{
+	"home": "685 Market St, San Francisco, CA 94103, USA",
+	"work": "1455 Market St, San Francisco, CA 94103, USA",
+}
+
+func (s *Server) savedPlace(req *http.Request) interface{} {
+	id := strings.TrimPrefix(req.URL.Path, RoutePlaces)
+	var body struct {
+		Address string `json:"address"`
+	}
+	if req.Method == http.MethodPut {
+		decodeJSON(req, &body)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if req.Method == http.MethodPut {
+		s.places[id] = body.Address
+	}
+	return map[string]interface{}{"address": s.places[id]}
+}
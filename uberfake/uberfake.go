@@ -0,0 +1,304 @@
+// Package uberfake provides an in-process fake of the Uber API and its
+// OAuth2.0 endpoints for exercising the uberclick server end to end with
+// httptest. Responses are canned by default and can be scripted per
+// route, optionally with latency.
+//
+//	fake := uberfake.New()
+//	defer fake.Close()
+//
+//	srv, err := server.New(&server.Options{
+//		Store:              memstore.New(),
+//		OAuth2ClientID:     "client-id",
+//		OAuth2ClientSecret: "client-secret",
+//		OAuth2Endpoint:     fake.OAuth2Endpoint(),
+//		Transport:          fake.Transport(),
+//	})
+//
+//	fake.Script(uberfake.RouteUpfrontFare, &uberfake.Response{
+//		Status:  http.StatusServiceUnavailable,
+//		Latency: 2 * time.Second,
+//	})
+package uberfake
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// Routes served by the fake, usable as keys for Script.
+const (
+	RouteAuthorize      = "/oauth/v2/authorize"
+	RouteToken          = "/oauth/v2/token"
+	RouteProducts       = "/v1.2/products"
+	RoutePriceEstimates = "/v1.2/estimates/price"
+	RouteTimeEstimates  = "/v1.2/estimates/time"
+	RouteUpfrontFare    = "/v1.2/requests/estimate"
+	RouteRideRequest    = "/v1.2/requests"
+	RouteProfile        = "/v1.2/me"
+	// RoutePlaces is followed by the ID of a saved place.
+	RoutePlaces = "/v1.2/places/"
+)
+
+// Hosts whose traffic Transport redirects to the fake.
+var upstreamHosts = map[string]bool{
+	"api.uber.com":         true,
+	"sandbox-api.uber.com": true,
+	"login.uber.com":       true,
+}
+
+// Response is a scripted reply for a route.
+type Response struct {
+	// Status defaults to http.StatusOK.
+	Status int
+	// Body is JSON encoded as the reply.
+	Body interface{}
+	// Latency is slept before replying.
+	Latency time.Duration
+}
+
+type Server struct {
+	ts *httptest.Server
+
+	mu       sync.Mutex
+	latency  time.Duration
+	scripts  map[string][]*Response
+	calls    map[string]int
+	codes    map[string]bool
+	tokens   map[string]bool
+	places   map[string]string
+	products []*Product
+	issueSeq int
+}
+
+// New starts a fake Uber API server. Callers must Close it.
+func New() *Server {
+	s := &Server{
+		scripts:  make(map[string][]*Response),
+		calls:    make(map[string]int),
+		codes:    make(map[string]bool),
+		tokens:   make(map[string]bool),
+		places:   make(map[string]string),
+		products: DefaultProducts(),
+	}
+	for id, address := range SavedPlaces {
+		s.places[id] = address
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(RouteAuthorize, s.authorize)
+	mux.HandleFunc(RouteToken, s.token)
+	mux.HandleFunc(RouteProducts, s.authenticated(RouteProducts, s.defaultProducts))
+	mux.HandleFunc(RoutePriceEstimates, s.authenticated(RoutePriceEstimates, s.defaultPriceEstimates))
+	mux.HandleFunc(RouteTimeEstimates, s.authenticated(RouteTimeEstimates, s.defaultTimeEstimates))
+	mux.HandleFunc(RouteUpfrontFare, s.authenticated(RouteUpfrontFare, s.defaultUpfrontFare))
+	mux.HandleFunc(RouteRideRequest, s.authenticated(RouteRideRequest, s.defaultRide))
+	mux.HandleFunc(RouteProfile, s.authenticated(RouteProfile, defaultProfile))
+	mux.HandleFunc(RoutePlaces, s.authenticated(RoutePlaces, s.savedPlace))
+	s.ts = httptest.NewServer(mux)
+	return s
+}
+
+func (s *Server) URL() string { return s.ts.URL }
+
+func (s *Server) Close() { s.ts.Close() }
+
+// OAuth2Endpoint returns the fake's authorization and token URLs.
+func (s *Server) OAuth2Endpoint() *oauth2.Endpoint {
+	return &oauth2.Endpoint{
+		AuthURL:  s.ts.URL + RouteAuthorize,
+		TokenURL: s.ts.URL + RouteToken,
+	}
+}
+
+// Transport returns a RoundTripper that sends requests
+// for the Uber API and login hosts to the fake instead.
+func (s *Server) Transport() http.RoundTripper {
+	fakeURL, _ := url.Parse(s.ts.URL)
+	return roundTripper(func(req *http.Request) (*http.Response, error) {
+		if upstreamHosts[req.URL.Hostname()] {
+			req = req.Clone(req.Context())
+			req.URL.Scheme = fakeURL.Scheme
+			req.URL.Host = fakeURL.Host
+			req.Host = fakeURL.Host
+		}
+		return http.DefaultTransport.RoundTrip(req)
+	})
+}
+
+type roundTripper func(*http.Request) (*http.Response, error)
+
+func (rt roundTripper) RoundTrip(req *http.Request) (*http.Response, error) { return rt(req) }
+
+// SetLatency sets the latency added to every reply.
+func (s *Server) SetLatency(d time.Duration) {
+	s.mu.Lock()
+	s.latency = d
+	s.mu.Unlock()
+}
+
+// Script queues responses for route that are replied,
+// in order, before falling back to the canned defaults.
+func (s *Server) Script(route string, responses ...*Response) {
+	s.mu.Lock()
+	s.scripts[route] = append(s.scripts[route], responses...)
+	s.mu.Unlock()
+}
+
+// Calls returns the number of requests received on route.
+func (s *Server) Calls(route string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[route]
+}
+
+// next records a call on route and returns its scripted
+// response or nil if the defaults should be used.
+func (s *Server) next(route string) (*Response, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.calls[route]++
+	queue := s.scripts[route]
+	if len(queue) == 0 {
+		return nil, s.latency
+	}
+	s.scripts[route] = queue[1:]
+	return queue[0], s.latency
+}
+
+func (s *Server) reply(rw http.ResponseWriter, req *http.Request, route string, fallback interface{}) {
+	resp, latency := s.next(route)
+	if resp == nil {
+		// Defaults may themselves be replies, such as
+		// when a lookup is for an unknown product.
+		if r, ok := fallback.(*Response); ok {
+			resp = r
+		} else {
+			resp = &Response{Body: fallback}
+		}
+	}
+	latency += resp.Latency
+	if latency > 0 {
+		select {
+		case <-time.After(latency):
+		case <-req.Context().Done():
+			return
+		}
+	}
+	status := resp.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	json.NewEncoder(rw).Encode(resp.Body)
+}
+
+func (s *Server) newSecret(prefix string) string {
+	s.issueSeq++
+	return fmt.Sprintf("%s-%d-%d", prefix, time.Now().UnixNano(), s.issueSeq)
+}
+
+// authorize behaves like a user that immediately consents
+// and redirects back to redirect_uri with a fresh code.
+func (s *Server) authorize(rw http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	redirectURL, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil || redirectURL.Host == "" {
+		http.Error(rw, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	if resp, _ := s.next(RouteAuthorize); resp != nil && resp.Status >= http.StatusBadRequest {
+		values := redirectURL.Query()
+		values.Set("error", "access_denied")
+		values.Set("state", query.Get("state"))
+		redirectURL.RawQuery = values.Encode()
+		http.Redirect(rw, req, redirectURL.String(), http.StatusFound)
+		return
+	}
+
+	s.mu.Lock()
+	code := s.newSecret("code")
+	s.codes[code] = true
+	s.mu.Unlock()
+
+	values := redirectURL.Query()
+	values.Set("code", code)
+	values.Set("state", query.Get("state"))
+	redirectURL.RawQuery = values.Encode()
+	http.Redirect(rw, req, redirectURL.String(), http.StatusFound)
+}
+
+func (s *Server) token(rw http.ResponseWriter, req *http.Request) {
+	if err := req.ParseForm(); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	var ok bool
+	switch req.PostForm.Get("grant_type") {
+	case "authorization_code":
+		code := req.PostForm.Get("code")
+		ok = s.codes[code]
+		delete(s.codes, code)
+	case "refresh_token":
+		ok = strings.HasPrefix(req.PostForm.Get("refresh_token"), "refresh-")
+	}
+	accessToken := s.newSecret("access")
+	if ok {
+		s.tokens[accessToken] = true
+	}
+	refreshToken := s.newSecret("refresh")
+	s.mu.Unlock()
+
+	if !ok {
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(rw, `{"error":"invalid_grant"}`)
+		return
+	}
+	s.reply(rw, req, RouteToken, map[string]interface{}{
+		"access_token":  accessToken,
+		"refresh_token": refreshToken,
+		"token_type":    "Bearer",
+		"expires_in":    2592000,
+		"scope":         "profile history places request request_receipt",
+	})
+}
+
+// authenticated only serves requests bearing
+// an access token issued by the token endpoint.
+func (s *Server) authenticated(route string, fallback func(*http.Request) interface{}) http.HandlerFunc {
+	return func(rw http.ResponseWriter, req *http.Request) {
+		accessToken := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+		s.mu.Lock()
+		ok := s.tokens[accessToken]
+		s.mu.Unlock()
+		if !ok {
+			rw.Header().Set("Content-Type", "application/json")
+			rw.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprintf(rw, `{"code":"unauthorized","message":"Invalid OAuth 2.0 credentials provided."}`)
+			return
+		}
+		s.reply(rw, req, route, fallback(req))
+	}
+}
+
+func decodeJSON(req *http.Request, recv interface{}) error {
+	if req.Body == nil {
+		return nil
+	}
+	defer req.Body.Close()
+	return json.NewDecoder(req.Body).Decode(recv)
+}
+
+func ftoa(f float64) string { return strconv.FormatFloat(f, 'f', -1, 64) }